@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/gavsidhu/miflo/internal/helpers"
+	"github.com/gavsidhu/miflo/internal/miflo"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of the check command.
+var checkExitCodes = map[miflo.CheckStatus]int{
+	miflo.CheckUpToDate: 0,
+	miflo.CheckPending:  1,
+	miflo.CheckDrift:    2,
+	miflo.CheckError:    3,
+}
+
+func init() {
+	checkCmd.Flags().Bool("json", false, "write the result as JSON")
+	rootCmd.AddCommand(checkCmd)
+}
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check whether the database is up to date",
+	Long: `The check command compares the database with the migrations directory without changing either, for use in CI pipelines and deploy gates. It reports pending migrations and repeatable migrations, applied migrations whose up SQL changed since they were applied, applied migrations whose directory is missing and dirty migrations.
+
+The exit status is 0 when the database is up to date, 1 when migrations are pending, 2 when applied migrations were changed, are missing or are dirty, and 3 when the database cannot be reached or the check fails. With --json the result is written to stdout as a JSON object.`,
+	Args:    cobra.NoArgs,
+	Example: "miflo check\nmiflo check --json",
+	Run: func(cmd *cobra.Command, args []string) {
+		result, err := checkDatabase(cmd)
+		if err != nil {
+			result = miflo.CheckResult{Status: miflo.CheckError, Error: helpers.RedactSecrets(err.Error())}
+		}
+
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			out, _ := json.MarshalIndent(result, "", "  ")
+			fmt.Println(string(out))
+		} else {
+			printCheck(result)
+		}
+
+		os.Exit(checkExitCodes[result.Status])
+	},
+}
+
+func checkDatabase(cmd *cobra.Command) (miflo.CheckResult, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return miflo.CheckResult{}, fmt.Errorf("error getting current working directory: %w", err)
+	}
+
+	ctx, stop := commandContext()
+	defer stop()
+
+	database, err := connectDatabase(ctx, cmd)
+	if err != nil {
+		return miflo.CheckResult{}, err
+	}
+
+	defer database.Close()
+
+	vars, err := templateVars(cmd)
+	if err != nil {
+		return miflo.CheckResult{}, err
+	}
+
+	return miflo.CheckMigrations(database, ctx, cwd, miflo.WithVars(vars))
+}
+
+func printCheck(result miflo.CheckResult) {
+	if result.Status == miflo.CheckError {
+		fmt.Fprintln(os.Stderr, result.Error)
+		return
+	}
+
+	for _, migration := range result.Dirty {
+		fmt.Println(helpers.ColorRed, migration, "(dirty, resolve it with miflo force)", helpers.ColorReset)
+	}
+	for _, migration := range result.ChecksumMismatch {
+		fmt.Println(helpers.ColorRed, migration, "(changed after it was applied)", helpers.ColorReset)
+	}
+	for _, migration := range result.Missing {
+		fmt.Println(helpers.ColorRed, migration, "(applied but its directory is missing)", helpers.ColorReset)
+	}
+	for _, migration := range result.Pending {
+		if helpers.Contains(result.OutOfOrder, migration) {
+			fmt.Println(helpers.ColorYellow, migration, "(pending, out of order)", helpers.ColorReset)
+			continue
+		}
+		fmt.Println(helpers.ColorYellow, migration, "(pending)", helpers.ColorReset)
+	}
+	for _, repeatable := range result.PendingRepeatable {
+		fmt.Println(helpers.ColorYellow, repeatable, "(pending repeatable)", helpers.ColorReset)
+	}
+
+	switch result.Status {
+	case miflo.CheckUpToDate:
+		fmt.Println(helpers.ColorGreen, "Database is up to date", helpers.ColorReset)
+	case miflo.CheckPending:
+		fmt.Println(helpers.ColorYellow, "Database has pending migrations", helpers.ColorReset)
+	case miflo.CheckDrift:
+		fmt.Println(helpers.ColorRed, "Applied migrations do not match the migrations directory", helpers.ColorReset)
+	}
+}
@@ -59,6 +59,9 @@ var createCmd = &cobra.Command{
 
 		if err := miflo.CreateMigration(migrationName, cwd, timestamp); err != nil {
 			fmt.Println("error creating migration: ", err)
+			return
 		}
+
+		fmt.Println("Migration created successfully")
 	},
 }
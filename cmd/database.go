@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/joho/godotenv"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.PersistentFlags().Int("connect-retries", 0, "retry connecting to the database this many times with exponential backoff, -1 retries until the command times out (default MIFLO_CONNECT_RETRIES)")
+	rootCmd.PersistentFlags().Duration("connect-retry-delay", database.DefaultRetryDelay, "wait before the first connection retry, doubled after every retry up to 30s (default MIFLO_CONNECT_RETRY_DELAY)")
+}
+
+// loadEnv loads the .env file if there is one. Without it the environment
+// must provide DATABASE_URL.
+func loadEnv() error {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("Error loading .env file: %w", err)
+	}
+	return nil
+}
+
+// connectRetry returns the retry policy set by --connect-retries and
+// --connect-retry-delay, or by MIFLO_CONNECT_RETRIES and
+// MIFLO_CONNECT_RETRY_DELAY when the flags are not set.
+func connectRetry(cmd *cobra.Command) (database.Retry, error) {
+	retries, _ := cmd.Flags().GetInt("connect-retries")
+	if env := os.Getenv("MIFLO_CONNECT_RETRIES"); env != "" && !cmd.Flags().Changed("connect-retries") {
+		n, err := strconv.Atoi(env)
+		if err != nil {
+			return database.Retry{}, fmt.Errorf("invalid MIFLO_CONNECT_RETRIES %q, expected a number", env)
+		}
+		retries = n
+	}
+
+	delay, _ := cmd.Flags().GetDuration("connect-retry-delay")
+	if env := os.Getenv("MIFLO_CONNECT_RETRY_DELAY"); env != "" && !cmd.Flags().Changed("connect-retry-delay") {
+		d, err := time.ParseDuration(env)
+		if err != nil {
+			return database.Retry{}, fmt.Errorf("invalid MIFLO_CONNECT_RETRY_DELAY %q, expected a duration such as 2s", env)
+		}
+		delay = d
+	}
+
+	return database.Retry{Attempts: retries, Delay: delay}, nil
+}
+
+// logRetry tells the user why miflo is waiting before connecting again.
+func logRetry(attempt int, delay time.Duration, err error) {
+	slog.Warn(fmt.Sprintf("database is not reachable, retrying in %s", delay), "attempt", attempt, "error", err)
+}
+
+// connectDatabase connects to DATABASE_URL, retrying as set by connectRetry
+// until ctx is done.
+func connectDatabase(ctx context.Context, cmd *cobra.Command) (database.Database, error) {
+	databaseConnection := os.Getenv("DATABASE_URL")
+	if databaseConnection == "" {
+		return nil, errors.New("DATABASE_URL is not set")
+	}
+
+	return connectURL(ctx, cmd, databaseConnection)
+}
+
+// connectURL connects to databaseConnection, retrying as set by connectRetry
+// until ctx is done.
+func connectURL(ctx context.Context, cmd *cobra.Command, databaseConnection string) (database.Database, error) {
+	retry, err := connectRetry(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := database.Connect(ctx, databaseConnection, retry, logRetry)
+	if err != nil {
+		return nil, fmt.Errorf("Error setting up database: %w", err)
+	}
+
+	return db, nil
+}
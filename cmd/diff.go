@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gavsidhu/miflo/internal/helpers"
+	"github.com/gavsidhu/miflo/internal/miflo"
+	"github.com/spf13/cobra"
+)
+
+// diffExitDrift is the exit status of the diff command when drift is found,
+// which tells it apart from the status 1 of a failed comparison.
+const diffExitDrift = 2
+
+func init() {
+	addScratchFlag(diffCmd)
+	rootCmd.AddCommand(diffCmd)
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Show schema drift between the database and the migrations",
+	Long: `The diff command replays every migration into a throwaway database of the same dialect, an in-memory SQLite database for SQLite and libSQL or a temporary schema for PostgreSQL, and compares its schema with the database at DATABASE_URL. Missing, extra and changed tables, columns, indexes, constraints and other objects are reported.
+
+The PostgreSQL temporary schema is created in DATABASE_URL and dropped afterwards, unless --scratch-url or MIFLO_SCRATCH_URL chooses another database for it. The exit status is 0 when the schemas match, 2 when drift is found and 1 when the comparison fails.`,
+	Args:    cobra.NoArgs,
+	Example: "miflo diff\nmiflo diff --scratch-url postgres://localhost/miflo_scratch",
+	Run: func(cmd *cobra.Command, args []string) {
+		cwd, err := os.Getwd()
+		if err != nil {
+			helpers.ErrAndExit(fmt.Sprint("error getting current working directory: ", err))
+		}
+
+		databaseConnection := os.Getenv("DATABASE_URL")
+		if databaseConnection == "" {
+			helpers.ErrAndExit("DATABASE_URL is not set")
+		}
+
+		vars, err := templateVars(cmd)
+		if err != nil {
+			helpers.ErrAndExit(err.Error())
+		}
+
+		scratchURL, err := scratchDatabaseURL(cmd, databaseConnection)
+		if err != nil {
+			helpers.ErrAndExit(err.Error())
+		}
+
+		ctx, stop := commandContext()
+		defer stop()
+
+		db, err := connectDatabase(ctx, cmd)
+		if err != nil {
+			helpers.ErrAndExit(err.Error())
+		}
+
+		differences, pending, err := miflo.DiffDatabase(ctx, db, scratchURL, cwd, miflo.WithVars(vars))
+		db.Close()
+		if err != nil {
+			helpers.ErrAndExit(fmt.Sprint("error comparing schemas: ", err))
+		}
+
+		if pending > 0 {
+			fmt.Println(helpers.ColorYellow, fmt.Sprintf("%d migration(s) are pending, their changes are reported as missing", pending), helpers.ColorReset)
+		}
+
+		if len(differences) < 1 {
+			fmt.Println(helpers.ColorGreen, "Database schema matches the migrations", helpers.ColorReset)
+			return
+		}
+
+		for _, difference := range differences {
+			color := helpers.ColorYellow
+			if difference.Kind == "missing" {
+				color = helpers.ColorRed
+			}
+			fmt.Println(color, difference, helpers.ColorReset)
+		}
+
+		fmt.Fprintf(os.Stderr, "found %d difference(s)\n", len(differences))
+		os.Exit(diffExitDrift)
+	},
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gavsidhu/miflo/internal/helpers"
+	"github.com/gavsidhu/miflo/internal/miflo"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	forceCmd.Flags().Bool("clean", false, "the SQL the migration failed in left no changes behind: record it as before it ran")
+	forceCmd.Flags().Bool("applied", false, "the SQL the migration failed in completed: record it as applied, or as reverted if it failed while reverting")
+	forceCmd.MarkFlagsMutuallyExclusive("clean", "applied")
+	rootCmd.AddCommand(forceCmd)
+}
+
+var forceCmd = &cobra.Command{
+	Use:   "force <version>",
+	Short: "Resolve a dirty migration",
+	Long: `The force command resolves a dirty migration, a migration that failed halfway while running outside of a transaction. Until it is resolved miflo refuses to apply or revert migrations because the database may be in an unknown state.
+
+Fix the database by hand first, then tell miflo how the SQL the migration failed in ended. The error miflo up and miflo revert report for a dirty database says whether it failed while being applied or reverted:
+
+  failed while applying   --clean   none of its changes are left, it is recorded as not applied
+                          --applied all of its changes are made, it is recorded as applied
+  failed while reverting  --clean   the revert left no changes behind, it stays applied
+                          --applied the revert completed, it is recorded as not applied
+
+The version is the migration name or its timestamp.`,
+	Args:    cobra.ExactArgs(1),
+	Example: "miflo force 1704662056 --clean\nmiflo force 1704662056_add_email_index --applied",
+	Run: func(cmd *cobra.Command, args []string) {
+		clean, _ := cmd.Flags().GetBool("clean")
+		applied, _ := cmd.Flags().GetBool("applied")
+		if !clean && !applied {
+			helpers.ErrAndExit("one of --clean or --applied is required")
+		}
+
+		ctx, stop := commandContext()
+		defer stop()
+
+		database, err := connectDatabase(ctx, cmd)
+		if err != nil {
+			helpers.ErrAndExit(err.Error())
+		}
+
+		defer database.Close()
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			helpers.ErrAndExit(fmt.Sprint("error getting current working directory: ", err))
+		}
+
+		vars, err := templateVars(cmd)
+		if err != nil {
+			helpers.ErrAndExit(err.Error())
+		}
+
+		migration, err := miflo.ForceMigration(database, ctx, cwd, args[0], applied, miflo.WithVars(vars))
+		if err != nil {
+			helpers.ErrAndExit(err.Error())
+		}
+
+		if applied == (migration.Direction == miflo.Up) {
+			fmt.Println("Recorded", migration.Name, "as applied")
+			return
+		}
+		fmt.Println("Recorded", migration.Name, "as not applied")
+	},
+}
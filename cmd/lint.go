@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/helpers"
+	"github.com/gavsidhu/miflo/internal/miflo"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	lintCmd.Flags().String("dialect", "", "SQL dialect to lint for: postgres, sqlite or libsql (defaults to the DATABASE_URL scheme)")
+	rootCmd.AddCommand(lintCmd)
+}
+
+var lintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Lint migrations for dangerous operations",
+	Long: `The lint command statically checks the up.sql and down.sql files of every migration for risky operations and exits with a non-zero status if any are found.
+
+Rules:
+  drop-without-down           DROP TABLE or DROP COLUMN that down.sql does not undo
+  not-null-without-default    ALTER TABLE ... ADD COLUMN ... NOT NULL without a DEFAULT (postgres)
+  index-without-concurrently  CREATE INDEX without CONCURRENTLY on an existing table (postgres)
+  sqlite-table-rewrite        operations that copy every row of a table (sqlite, libsql)
+  down-missing-if-exists      DROP statements in down.sql without IF EXISTS
+  empty-down                  empty or missing down.sql
+
+A rule can be disabled for a single statement with a "-- miflo:ignore rule-name" comment before or on the same line as the statement.`,
+	Args:    cobra.NoArgs,
+	Example: "miflo lint\nmiflo lint --dialect postgres",
+	Run: func(cmd *cobra.Command, args []string) {
+		cwd, err := os.Getwd()
+		if err != nil {
+			helpers.ErrAndExit(fmt.Sprint("error getting current working directory: ", err))
+		}
+
+		dialect, _ := cmd.Flags().GetString("dialect")
+		if dialect == "" {
+			databaseConnection := os.Getenv("DATABASE_URL")
+			if databaseConnection == "" {
+				helpers.ErrAndExit("DATABASE_URL is not set, use --dialect to choose a dialect")
+			}
+
+			dialect, err = database.DialectName(databaseConnection)
+			if err != nil {
+				helpers.ErrAndExit(err.Error())
+			}
+		}
+
+		switch dialect {
+		case "postgres", "sqlite", "libsql":
+		default:
+			helpers.ErrAndExit(fmt.Sprintf("unsupported dialect %q, expected postgres, sqlite or libsql", dialect))
+		}
+
+		findings, err := miflo.LintMigrations(cwd, dialect)
+		if err != nil {
+			helpers.ErrAndExit(fmt.Sprint("error linting migrations: ", err))
+		}
+
+		if len(findings) < 1 {
+			fmt.Println(helpers.ColorGreen, "No problems found", helpers.ColorReset)
+			return
+		}
+
+		for _, finding := range findings {
+			fmt.Println(helpers.ColorYellow, finding, helpers.ColorReset)
+		}
+
+		helpers.ErrAndExit(fmt.Sprintf("found %d problem(s)", len(findings)))
+	},
+}
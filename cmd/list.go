@@ -2,11 +2,12 @@ package cmd
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
+	"path"
 
-	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/helpers"
 	"github.com/gavsidhu/miflo/internal/miflo"
-	"github.com/joho/godotenv"
 	"github.com/spf13/cobra"
 )
 
@@ -21,21 +22,12 @@ var listCmd = &cobra.Command{
 	Args:    cobra.NoArgs,
 	Example: "miflo list",
 	Run: func(cmd *cobra.Command, args []string) {
-		err := godotenv.Load()
-		if err != nil {
-			fmt.Println("Error loading .env file")
-			return
-		}
-
-		databaseConnection := os.Getenv("DATABASE_URL")
-		if databaseConnection == "" {
-			fmt.Println("DATABASE_URL is not set")
-			return
-		}
+		ctx, stop := commandContext()
+		defer stop()
 
-		database, err := database.NewDatabase(databaseConnection)
+		database, err := connectDatabase(ctx, cmd)
 		if err != nil {
-			fmt.Println(err)
+			slog.Error(err.Error())
 			return
 		}
 
@@ -46,9 +38,43 @@ var listCmd = &cobra.Command{
 			fmt.Println("error getting current working directory")
 		}
 
-		if err := miflo.ListPendingMigrations(database, cwd); err != nil {
-			fmt.Println(err)
+		vars, err := templateVars(cmd)
+		if err != nil {
+			slog.Error(err.Error())
 			return
 		}
+
+		pending, err := miflo.ListPendingMigrations(database, ctx, cwd, miflo.WithVars(vars))
+		if err != nil {
+			slog.Error(err.Error())
+			return
+		}
+
+		printPending(pending)
 	},
 }
+
+func printPending(pending miflo.PendingMigrations) {
+	for _, dirty := range pending.Dirty {
+		fmt.Println(helpers.ColorRed, dirty, "(dirty, resolve it with miflo force)", helpers.ColorReset)
+	}
+
+	if len(pending.Migrations) < 1 && len(pending.Repeatables) < 1 {
+		fmt.Println("No pending migrations")
+		return
+	}
+
+	fmt.Println("Pending migrations:")
+
+	for _, migration := range pending.Migrations {
+		if helpers.Contains(pending.OutOfOrder, migration) {
+			fmt.Println(helpers.ColorRed, migration, "(out of order)", helpers.ColorReset)
+			continue
+		}
+		fmt.Println(helpers.ColorYellow, migration, helpers.ColorReset)
+	}
+
+	for _, repeatable := range pending.Repeatables {
+		fmt.Println(helpers.ColorYellow, path.Join(helpers.RepeatableDir, repeatable.Name), "(repeatable)", helpers.ColorReset)
+	}
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/gavsidhu/miflo/internal/logging"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.PersistentFlags().CountP("verbose", "v", "log the statements miflo executes, -vv also logs the queries it runs on its own tables")
+	rootCmd.PersistentFlags().BoolP("quiet", "q", false, "only log warnings and errors")
+	rootCmd.PersistentFlags().String("log-format", "text", "log format, text or json (default MIFLO_LOG_FORMAT)")
+	rootCmd.MarkFlagsMutuallyExclusive("verbose", "quiet")
+	rootCmd.PersistentPreRunE = setupLogging
+}
+
+// setupLogging loads the .env file, so every command and the logging
+// settings see the variables it sets, and makes the logger set by the logging
+// flags the default logger, which the library uses unless it is given
+// another one.
+func setupLogging(cmd *cobra.Command, args []string) error {
+	if err := loadEnv(); err != nil {
+		return err
+	}
+
+	level := slog.LevelInfo
+	if env := os.Getenv("MIFLO_LOG_LEVEL"); env != "" {
+		var err error
+		if level, err = parseLogLevel(env); err != nil {
+			return err
+		}
+	}
+	if quiet, _ := cmd.Flags().GetBool("quiet"); quiet {
+		level = slog.LevelWarn
+	}
+	switch verbose, _ := cmd.Flags().GetCount("verbose"); {
+	case verbose == 1:
+		level = slog.LevelDebug
+	case verbose > 1:
+		level = logging.LevelTrace
+	}
+
+	format, _ := cmd.Flags().GetString("log-format")
+	if env := os.Getenv("MIFLO_LOG_FORMAT"); env != "" && !cmd.Flags().Changed("log-format") {
+		format = env
+	}
+
+	logger, err := logging.New(os.Stderr, format, level)
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(logger)
+	return nil
+}
+
+// parseLogLevel parses the level set by MIFLO_LOG_LEVEL.
+func parseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "trace":
+		return logging.LevelTrace, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("invalid log level %q, expected trace, debug, info, warn or error", level)
+	}
+}
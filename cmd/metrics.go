@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/gavsidhu/miflo/internal/helpers"
+	"github.com/gavsidhu/miflo/internal/metrics"
+	"github.com/gavsidhu/miflo/internal/miflo"
+	"github.com/spf13/cobra"
+)
+
+func addMetricsFlag(cmd *cobra.Command) {
+	cmd.Flags().String("metrics-file", "", "write OpenMetrics for node_exporter's textfile collector to this file after the run (default MIFLO_METRICS_FILE)")
+}
+
+// metricsFile records the runs of a command in the file given by
+// --metrics-file or MIFLO_METRICS_FILE. Its methods do nothing when neither is
+// set.
+type metricsFile struct {
+	path     string
+	registry *metrics.Registry
+	runs     atomic.Int64
+}
+
+// openMetricsFile reads the metrics written by previous runs, so failure
+// counts and success timestamps keep adding up.
+func openMetricsFile(cmd *cobra.Command) (*metricsFile, error) {
+	path, _ := cmd.Flags().GetString("metrics-file")
+	if path == "" {
+		path = os.Getenv("MIFLO_METRICS_FILE")
+	}
+	if path == "" {
+		return nil, nil
+	}
+
+	registry, err := metrics.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &metricsFile{path: path, registry: registry}, nil
+}
+
+// options returns the option that records the runs of ApplyMigrations,
+// RevertMigrations and ApplyMigrationsToTargets.
+func (m *metricsFile) options() []miflo.Option {
+	if m == nil {
+		return nil
+	}
+	return []miflo.Option{miflo.WithRunSummary(m.record)}
+}
+
+// record records a run.
+func (m *metricsFile) record(summary miflo.RunSummary) {
+	if m == nil {
+		return
+	}
+
+	target := summaryTarget(summary)
+	if target == "" {
+		return
+	}
+
+	m.registry.Record(target, summary)
+	m.runs.Add(1)
+}
+
+// summaryTarget names the target of a run, using the redacted DATABASE_URL
+// for runs that are not part of ApplyMigrationsToTargets.
+func summaryTarget(summary miflo.RunSummary) string {
+	if summary.Target != "" {
+		return summary.Target
+	}
+	return helpers.RedactURL(os.Getenv("DATABASE_URL"))
+}
+
+// recordFailure records a run that failed before it could start, such as
+// when the database could not be reached.
+func (m *metricsFile) recordFailure(direction miflo.Direction, start time.Time, err error) {
+	m.record(miflo.RunSummary{Direction: direction, Start: start, Duration: time.Since(start), Err: err})
+}
+
+// save writes the metrics if a run was recorded. Failing to write them is
+// only a warning so it does not hide the outcome of the migrations.
+func (m *metricsFile) save() {
+	if m == nil || m.runs.Load() == 0 {
+		return
+	}
+
+	if err := m.registry.WriteFile(m.path); err != nil {
+		slog.Warn(err.Error())
+	}
+}
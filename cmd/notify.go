@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/miflo"
+	"github.com/gavsidhu/miflo/internal/notify"
+	"github.com/spf13/cobra"
+)
+
+func addNotifyFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArray("notify-webhook", nil, "POST the outcome of the run as JSON to this URL (can be repeated, defaults to MIFLO_NOTIFY_WEBHOOK)")
+	cmd.Flags().StringArray("notify-exec", nil, "run this shell command with the outcome of the run as JSON on its standard input (can be repeated, defaults to MIFLO_NOTIFY_EXEC)")
+	cmd.Flags().Int("notify-retries", 3, "retry a failed notification this many times with exponential backoff")
+	cmd.Flags().String("notify-failure", "", "what to do when a notification cannot be delivered: warn, or error to exit with status 1 (default \"warn\", or MIFLO_NOTIFY_FAILURE)")
+}
+
+// notifications sends the outcome of the runs of a command to the notifiers
+// set by --notify-webhook, --notify-exec and their environment variables.
+// Its methods do nothing when no notifier is set.
+type notifications struct {
+	notifiers   []notify.Notifier
+	retry       database.Retry
+	failOnError bool
+
+	mu      sync.Mutex
+	pending []notify.Notification
+}
+
+func openNotifications(cmd *cobra.Command) (*notifications, error) {
+	webhooks, _ := cmd.Flags().GetStringArray("notify-webhook")
+	if env := os.Getenv("MIFLO_NOTIFY_WEBHOOK"); env != "" && !cmd.Flags().Changed("notify-webhook") {
+		webhooks = []string{env}
+	}
+
+	commands, _ := cmd.Flags().GetStringArray("notify-exec")
+	if env := os.Getenv("MIFLO_NOTIFY_EXEC"); env != "" && !cmd.Flags().Changed("notify-exec") {
+		commands = []string{env}
+	}
+
+	if len(webhooks) == 0 && len(commands) == 0 {
+		return nil, nil
+	}
+
+	failure, _ := cmd.Flags().GetString("notify-failure")
+	if failure == "" {
+		failure = os.Getenv("MIFLO_NOTIFY_FAILURE")
+	}
+	if failure != "" && failure != "warn" && failure != "error" {
+		return nil, fmt.Errorf("invalid notify failure policy %q, expected warn or error", failure)
+	}
+
+	retries, _ := cmd.Flags().GetInt("notify-retries")
+
+	n := &notifications{
+		retry:       database.Retry{Attempts: retries},
+		failOnError: failure == "error",
+	}
+	for _, url := range webhooks {
+		n.notifiers = append(n.notifiers, notify.Webhook{URL: url})
+	}
+	for _, command := range commands {
+		n.notifiers = append(n.notifiers, notify.Command{Command: command})
+	}
+
+	return n, nil
+}
+
+// options returns the option that records the runs of ApplyMigrations,
+// RevertMigrations and ApplyMigrationsToTargets.
+func (n *notifications) options() []miflo.Option {
+	if n == nil {
+		return nil
+	}
+	return []miflo.Option{miflo.WithRunSummary(n.record)}
+}
+
+// record queues a notification for a run. Successful runs that had nothing
+// to do are not notified.
+func (n *notifications) record(summary miflo.RunSummary) {
+	if n == nil || (summary.Err == nil && len(summary.Migrations) == 0) {
+		return
+	}
+
+	target := summaryTarget(summary)
+	if target == "" {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.pending = append(n.pending, notify.NewNotification(target, summary))
+}
+
+// recordFailure queues a notification for a run that failed before it could
+// start, such as when the database could not be reached.
+func (n *notifications) recordFailure(direction miflo.Direction, start time.Time, err error) {
+	n.record(miflo.RunSummary{Direction: direction, Start: start, Duration: time.Since(start), Err: err})
+}
+
+// send delivers the queued notifications. It is called once the outcome of
+// the migrations has been reported, and a notification that cannot be
+// delivered is only logged, unless --notify-failure is error, in which case
+// send returns an error after sending the others so miflo exits with status
+// 1. The error is joined after the error of the run, never in its place.
+func (n *notifications) send() error {
+	if n == nil || len(n.pending) == 0 {
+		return nil
+	}
+
+	ctx, stop := commandContext()
+	defer stop()
+
+	failed := 0
+	for _, notification := range n.pending {
+		for _, notifier := range n.notifiers {
+			err := notify.Send(ctx, notifier, notification, n.retry, func(attempt int, delay time.Duration, err error) {
+				slog.Warn(fmt.Sprintf("notification failed, retrying in %s", delay), "attempt", attempt, "error", err)
+			})
+			if err != nil {
+				failed++
+				slog.Warn(err.Error(), "target", notification.Target)
+			}
+		}
+	}
+
+	if failed > 0 && n.failOnError {
+		return fmt.Errorf("%d notification(s) could not be delivered", failed)
+	}
+
+	return nil
+}
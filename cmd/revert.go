@@ -1,57 +1,85 @@
 package cmd
 
 import (
-	"context"
+	"errors"
 	"fmt"
 	"os"
+	"time"
 
-	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/helpers"
 	"github.com/gavsidhu/miflo/internal/miflo"
-	"github.com/joho/godotenv"
 	"github.com/spf13/cobra"
 )
 
 func init() {
+	revertCmd.Flags().Bool("force", false, "remove irreversible migrations and migrations with an empty down.sql from the migrations table without reverting them")
+	addTimeoutFlags(revertCmd)
+	addMetricsFlag(revertCmd)
+	addNotifyFlags(revertCmd)
 	rootCmd.AddCommand(revertCmd)
 }
 
 var revertCmd = &cobra.Command{
 	Use:     "revert",
 	Short:   "Revert lat migration",
-	Long:    "The revert command rolls back all the database migrations that were most recently applied using the up command.",
+	Long:    "The revert command rolls back all the database migrations that were most recently applied using the up command. The down.sql files of the whole batch are checked before anything is reverted, and the batch is refused if it contains a migration marked with \"-- miflo:irreversible\", a migration without a down.sql or an empty down.sql, unless --force is set.",
 	Args:    cobra.NoArgs,
-	Example: "miflo revert",
+	Example: "miflo revert\nmiflo revert --force",
 	Run: func(cmd *cobra.Command, args []string) {
-		err := godotenv.Load()
-		if err != nil {
-			fmt.Println("Error loading .env file")
-			return
+		if err := revertBatch(cmd); err != nil {
+			helpers.ErrAndExit(err.Error())
 		}
+	},
+}
 
-		cwd, err := os.Getwd()
-		if err != nil {
-			fmt.Println("error getting current working directory: ", err)
-		}
+// revertBatch reverts the latest batch of DATABASE_URL. It returns the error
+// that failed the run once the metrics and notifications are written.
+func revertBatch(cmd *cobra.Command) (err error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("error getting current working directory: %w", err)
+	}
 
-		databaseConnection := os.Getenv("DATABASE_URL")
-		if databaseConnection == "" {
-			fmt.Println("DATABASE_URL is not set")
-			return
-		}
+	ctx, stop := runContext(cmd)
+	defer stop()
 
-		database, err := database.NewDatabase(databaseConnection)
-		if err != nil {
-			fmt.Println("Error setting up database:", err)
-			return
-		}
+	notifications, err := openNotifications(cmd)
+	if err != nil {
+		return err
+	}
 
-		defer database.Close()
+	defer func() {
+		err = errors.Join(err, notifications.send())
+	}()
 
-		ctx := context.Background()
+	metricsFile, err := openMetricsFile(cmd)
+	if err != nil {
+		return err
+	}
 
-		if err := miflo.RevertMigrations(database, ctx, cwd); err != nil {
-			fmt.Println(err)
-			return
-		}
-	},
+	defer metricsFile.save()
+
+	start := time.Now()
+	database, err := connectDatabase(ctx, cmd)
+	if err != nil {
+		metricsFile.recordFailure(miflo.Down, start, err)
+		notifications.recordFailure(miflo.Down, start, err)
+		return err
+	}
+
+	defer database.Close()
+
+	vars, err := templateVars(cmd)
+	if err != nil {
+		return err
+	}
+
+	opts := append([]miflo.Option{miflo.WithVars(vars)}, timeoutOptions(cmd)...)
+	opts = append(opts, metricsFile.options()...)
+	opts = append(opts, notifications.options()...)
+	if force, _ := cmd.Flags().GetBool("force"); force {
+		opts = append(opts, miflo.WithForce())
+	}
+
+	return miflo.RevertMigrations(database, ctx, cwd, opts...)
 }
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/spf13/cobra"
+)
+
+func addScratchFlag(cmd *cobra.Command) {
+	cmd.Flags().String("scratch-url", "", "PostgreSQL database the temporary schema the migrations are replayed into is created in (defaults to MIFLO_SCRATCH_URL, then DATABASE_URL, SQLite and libSQL use an in-memory database)")
+}
+
+// scratchDatabaseURL returns the database the migrations are replayed into:
+// the one set by --scratch-url or MIFLO_SCRATCH_URL, or databaseURL. On
+// PostgreSQL a temporary schema is created in it and dropped afterwards, and
+// SQLite and libSQL replay into an in-memory database, so databaseURL only
+// chooses the dialect.
+func scratchDatabaseURL(cmd *cobra.Command, databaseURL string) (string, error) {
+	scratchURL, _ := cmd.Flags().GetString("scratch-url")
+	if scratchURL == "" {
+		scratchURL = os.Getenv("MIFLO_SCRATCH_URL")
+	}
+	if scratchURL == "" {
+		scratchURL = databaseURL
+	}
+
+	if scratchURL == "" {
+		return "", errors.New("DATABASE_URL is not set, use --scratch-url to choose a scratch database")
+	}
+
+	if _, err := database.DialectName(scratchURL); err != nil {
+		return "", err
+	}
+
+	return scratchURL, nil
+}
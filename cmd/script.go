@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/helpers"
+	"github.com/gavsidhu/miflo/internal/miflo"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	scriptCmd.Flags().String("from", "", "migration the database has already applied, the script starts with the one after it (defaults to the first migration)")
+	scriptCmd.Flags().String("to", "", "last migration the script applies (defaults to the latest migration)")
+	scriptCmd.Flags().String("dialect", "", "SQL dialect of the script: postgres, sqlite or libsql (defaults to the DATABASE_URL scheme)")
+	scriptCmd.Flags().Bool("pending", false, "script the migrations pending on the database at DATABASE_URL, which is read but not changed")
+	scriptCmd.Flags().String("target", "", "with --pending, the URL of the database to read instead of DATABASE_URL")
+	scriptCmd.Flags().StringP("output", "o", "", "write the script to this file instead of standard output")
+	scriptCmd.Flags().String("out-of-order", "", "with --pending, what to do with pending migrations older than the latest applied one: error, warn or allow (default \"error\", or MIFLO_OUT_OF_ORDER)")
+	rootCmd.AddCommand(scriptCmd)
+}
+
+var scriptCmd = &cobra.Command{
+	Use:   "script",
+	Short: "Write the SQL of a range of migrations as one reviewable script",
+	Long: `The script command writes a single SQL script that applies migrations without miflo connecting to the database, for databases that are changed by a DBA. Running the script leaves the database in the state miflo up would: the migrations and hooks run in a transaction where the dialect allows, and the miflo tables record them with their batch, checksum and duration.
+
+With --from and --to the script applies the migrations after --from up to and including --to, for a database that has applied --from. Repeatable migrations are included when the script goes up to the latest migration. With --pending the script applies what miflo up would apply to DATABASE_URL, or to --target, including the repeatable migrations that changed.`,
+	Args:    cobra.NoArgs,
+	Example: "miflo script --dialect postgres -o deploy.sql\nmiflo script --from 1704662056 --to 1706000000 --dialect postgres\nmiflo script --pending --target postgres://readonly@prod/app -o deploy.sql",
+	Run: func(cmd *cobra.Command, args []string) {
+		cwd, err := os.Getwd()
+		if err != nil {
+			helpers.ErrAndExit(fmt.Sprint("error getting current working directory: ", err))
+		}
+
+		from, _ := cmd.Flags().GetString("from")
+		to, _ := cmd.Flags().GetString("to")
+		dialectName, _ := cmd.Flags().GetString("dialect")
+		target, _ := cmd.Flags().GetString("target")
+		pending, _ := cmd.Flags().GetBool("pending")
+		pending = pending || target != ""
+
+		if pending && (from != "" || to != "" || dialectName != "") {
+			helpers.ErrAndExit("--pending cannot be combined with --from, --to or --dialect")
+		}
+
+		var script bytes.Buffer
+		if pending {
+			if target == "" {
+				target = os.Getenv("DATABASE_URL")
+			}
+			if target == "" {
+				helpers.ErrAndExit("DATABASE_URL is not set, use --target to choose a database")
+			}
+
+			opts, err := upOptions(cmd)
+			if err != nil {
+				helpers.ErrAndExit(err.Error())
+			}
+
+			ctx, stop := commandContext()
+			defer stop()
+
+			db, err := connectURL(ctx, cmd, target)
+			if err != nil {
+				helpers.ErrAndExit(err.Error())
+			}
+
+			defer db.Close()
+
+			if err := miflo.WritePendingScript(&script, db, ctx, cwd, opts...); err != nil {
+				helpers.ErrAndExit(fmt.Sprint("error writing script: ", err))
+			}
+		} else {
+			if dialectName == "" {
+				databaseConnection := os.Getenv("DATABASE_URL")
+				if databaseConnection == "" {
+					helpers.ErrAndExit("DATABASE_URL is not set, use --dialect to choose a dialect")
+				}
+
+				dialectName, err = database.DialectName(databaseConnection)
+				if err != nil {
+					helpers.ErrAndExit(err.Error())
+				}
+			}
+
+			dialect, err := database.LookupDialect(dialectName)
+			if err != nil {
+				helpers.ErrAndExit(err.Error())
+			}
+
+			vars, err := templateVars(cmd)
+			if err != nil {
+				helpers.ErrAndExit(err.Error())
+			}
+
+			if err := miflo.WriteScript(&script, cwd, dialect, miflo.ScriptRange{From: from, To: to}, miflo.WithVars(vars)); err != nil {
+				helpers.ErrAndExit(fmt.Sprint("error writing script: ", err))
+			}
+		}
+
+		output, _ := cmd.Flags().GetString("output")
+		if output == "" {
+			fmt.Print(script.String())
+			return
+		}
+
+		if err := os.WriteFile(output, script.Bytes(), 0644); err != nil {
+			helpers.ErrAndExit(fmt.Sprint("error writing script: ", err))
+		}
+	},
+}
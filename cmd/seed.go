@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gavsidhu/miflo/internal/helpers"
+	"github.com/gavsidhu/miflo/internal/miflo"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	seedCmd.PersistentFlags().String("env", "", "environment whose seeds are used in addition to the shared seeds (seed run defaults to MIFLO_ENV)")
+	seedRunCmd.Flags().Bool("rerun", false, "run seeds again even if they have already run")
+	seedCmd.AddCommand(seedCreateCmd)
+	seedCmd.AddCommand(seedRunCmd)
+	rootCmd.AddCommand(seedCmd)
+}
+
+var seedCmd = &cobra.Command{
+	Use:   "seed",
+	Short: "Create and run seed data scripts",
+	Long:  "Seeds are SQL files in the seeds folder that insert data. Seeds directly in the seeds folder run in every environment, seeds in seeds/<env> only run when that environment is selected. Each seed runs once unless it is rerun.",
+}
+
+var seedCreateCmd = &cobra.Command{
+	Use:     "create <seed name>",
+	Short:   "Create a seed",
+	Long:    "The seed create command creates a new seed file in the seeds folder, or in seeds/<env> when --env is set. The folder is created if it does not exist.",
+	Args:    cobra.ExactArgs(1),
+	Example: "miflo seed create users\nmiflo seed create demo_accounts --env dev",
+	Run: func(cmd *cobra.Command, args []string) {
+		cwd, err := os.Getwd()
+		if err != nil {
+			fmt.Println("error getting current working directory: ", err)
+			return
+		}
+
+		env, _ := cmd.Flags().GetString("env")
+
+		pathName, err := miflo.CreateSeed(args[0], env, cwd, time.Now().Unix())
+		if err != nil {
+			fmt.Println("error creating seed: ", err)
+			return
+		}
+
+		fmt.Println("Seed created successfully:", pathName)
+	},
+}
+
+var seedRunCmd = &cobra.Command{
+	Use:     "run [seed name]",
+	Short:   "Run seeds",
+	Long:    "The seed run command runs every seed that has not run yet, or only the named seed. Seeds run in order of their timestamps in a single transaction. Use --rerun to run seeds that have already run again.",
+	Args:    cobra.MaximumNArgs(1),
+	Example: "miflo seed run\nmiflo seed run --env dev\nmiflo seed run users --rerun",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
+		database, err := connectDatabase(ctx, cmd)
+		if err != nil {
+			helpers.ErrAndExit(err.Error())
+		}
+
+		defer database.Close()
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			helpers.ErrAndExit(fmt.Sprintf("error getting current working directory: %v", err))
+		}
+
+		env, _ := cmd.Flags().GetString("env")
+		if env == "" {
+			env = os.Getenv("MIFLO_ENV")
+		}
+
+		vars, err := templateVars(cmd)
+		if err != nil {
+			database.Close()
+			helpers.ErrAndExit(err.Error())
+		}
+
+		opts := []miflo.Option{miflo.WithSeedEnv(env), miflo.WithVars(vars)}
+		if rerun, _ := cmd.Flags().GetBool("rerun"); rerun {
+			opts = append(opts, miflo.WithRerun())
+		}
+		if len(args) > 0 {
+			opts = append(opts, miflo.WithOnlySeed(args[0]))
+		}
+
+		ran, err := miflo.RunSeeds(database, ctx, cwd, opts...)
+		if err != nil {
+			database.Close()
+			helpers.ErrAndExit(err.Error())
+		}
+
+		if len(ran) < 1 {
+			fmt.Println("no seeds to run")
+			return
+		}
+
+		for _, seed := range ran {
+			fmt.Println(helpers.ColorGreen, "Ran", seed, helpers.ColorReset)
+		}
+	},
+}
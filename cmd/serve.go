@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gavsidhu/miflo/internal/helpers"
+	"github.com/gavsidhu/miflo/internal/miflo"
+	"github.com/gavsidhu/miflo/internal/server"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	serveCmd.Flags().String("addr", "127.0.0.1:8080", "address to listen on")
+	serveCmd.Flags().String("token", "", "bearer token the requests must send (default MIFLO_SERVE_TOKEN)")
+	serveCmd.Flags().String("out-of-order", "", "what to do with pending migrations older than the latest applied one: error, warn or allow (default \"error\", or MIFLO_OUT_OF_ORDER)")
+	addTimeoutFlags(serveCmd)
+	rootCmd.AddCommand(serveCmd)
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the status of the migrations and apply them over HTTP",
+	Long: `The serve command runs miflo as a small HTTP server for the database at DATABASE_URL. Every endpoint except /healthz requires the token set by --token or MIFLO_SERVE_TOKEN as a bearer token.
+
+  GET  /healthz  whether the database is reachable
+  GET  /status   the applied migrations with their batch and the pending ones, as JSON
+  GET  /plan     the SQL up would run
+  POST /up       apply the pending migrations
+  POST /revert   revert the latest batch
+  GET  /metrics  the metrics of the database in the OpenMetrics format
+
+Up and revert take the migration lock like the commands of the same name, one run at a time, and are rolled back when the client disconnects or --timeout expires.`,
+	Args:    cobra.NoArgs,
+	Example: "MIFLO_SERVE_TOKEN=secret miflo serve\nmiflo serve --addr :9000 --timeout 10m",
+	Run: func(cmd *cobra.Command, args []string) {
+		token, _ := cmd.Flags().GetString("token")
+		if token == "" {
+			token = os.Getenv("MIFLO_SERVE_TOKEN")
+		}
+		if token == "" {
+			helpers.ErrAndExit("a token is required, set MIFLO_SERVE_TOKEN or --token")
+		}
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			helpers.ErrAndExit(fmt.Sprint("error getting current working directory: ", err))
+		}
+
+		ctx, stop := commandContext()
+		defer stop()
+
+		database, err := connectDatabase(ctx, cmd)
+		if err != nil {
+			helpers.ErrAndExit(err.Error())
+		}
+
+		defer database.Close()
+
+		upOpts, err := upOptions(cmd)
+		if err != nil {
+			helpers.ErrAndExit(err.Error())
+		}
+
+		vars, err := templateVars(cmd)
+		if err != nil {
+			helpers.ErrAndExit(err.Error())
+		}
+
+		runTimeout, _ := cmd.Flags().GetDuration("timeout")
+		addr, _ := cmd.Flags().GetString("addr")
+
+		httpServer := &http.Server{
+			Addr: addr,
+			Handler: server.New(server.Config{
+				DB:            database,
+				Cwd:           cwd,
+				Token:         token,
+				Target:        helpers.RedactURL(os.Getenv("DATABASE_URL")),
+				UpOptions:     upOpts,
+				RevertOptions: append([]miflo.Option{miflo.WithVars(vars)}, timeoutOptions(cmd)...),
+				RunTimeout:    runTimeout,
+			}),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+
+		errs := make(chan error, 1)
+		go func() {
+			errs <- httpServer.ListenAndServe()
+		}()
+
+		slog.Info("listening on " + addr)
+
+		select {
+		case err := <-errs:
+			helpers.ErrAndExit(err.Error())
+		case <-ctx.Done():
+		}
+
+		// A second signal stops miflo without waiting for a running
+		// migration to finish.
+		stop()
+		slog.Info("shutting down, waiting for running requests")
+		if err := httpServer.Shutdown(context.Background()); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			helpers.ErrAndExit(err.Error())
+		}
+	},
+}
@@ -0,0 +1,16 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// commandContext returns a context that is canceled when the process receives
+// SIGINT or SIGTERM, so the running statement is canceled, the open
+// transaction is rolled back and the migration lock is released instead of
+// the process being killed halfway through a migration.
+func commandContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
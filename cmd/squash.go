@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gavsidhu/miflo/internal/helpers"
+	"github.com/gavsidhu/miflo/internal/miflo"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	squashCmd.Flags().String("to", "", "timestamp of the newest migration to squash")
+	addScratchFlag(squashCmd)
+	squashCmd.Flags().BoolP("yes", "y", false, "do not prompt for confirmation")
+	squashCmd.MarkFlagRequired("to")
+	rootCmd.AddCommand(squashCmd)
+}
+
+var squashCmd = &cobra.Command{
+	Use:     "squash --to <version>",
+	Short:   "Squash old migrations into a single baseline migration",
+	Long:    "The squash command replays every migration up to and including the given version into a scratch database and replaces them with a single migration generated from its schema. The baseline is irreversible. The original migrations are moved to the migrations_archive folder. Databases that already applied the originals record the squashed migration as applied in batch 0 the next time up runs. Data inserted by the squashed migrations is not carried over.",
+	Args:    cobra.NoArgs,
+	Example: "miflo squash --to 1704662056",
+	Run: func(cmd *cobra.Command, args []string) {
+		cwd, err := os.Getwd()
+		if err != nil {
+			helpers.ErrAndExit(fmt.Sprint("error getting current working directory: ", err))
+		}
+
+		to, _ := cmd.Flags().GetString("to")
+
+		scratchURL, err := scratchDatabaseURL(cmd, os.Getenv("DATABASE_URL"))
+		if err != nil {
+			helpers.ErrAndExit(err.Error())
+		}
+
+		vars, err := templateVars(cmd)
+		if err != nil {
+			helpers.ErrAndExit(err.Error())
+		}
+
+		migrations, _, err := miflo.MigrationsToSquash(cwd, to)
+		if err != nil {
+			helpers.ErrAndExit(err.Error())
+		}
+
+		if yes, _ := cmd.Flags().GetBool("yes"); !yes {
+			if !helpers.PromptForConfirmation(fmt.Sprintf("Squash %d migration(s) and move them to migrations_archive?", len(migrations))) {
+				return
+			}
+		}
+
+		ctx, stop := commandContext()
+		defer stop()
+
+		squashed, err := miflo.SquashMigrations(ctx, scratchURL, cwd, to, miflo.WithVars(vars))
+		if err != nil {
+			helpers.ErrAndExit(fmt.Sprint("error squashing migrations: ", err))
+		}
+
+		fmt.Printf("Squashed %d migration(s) into %s\n", len(migrations), squashed)
+	},
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/gavsidhu/miflo/internal/helpers"
+	"github.com/gavsidhu/miflo/internal/miflo"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	statsCmd.Flags().Int("limit", 10, "number of slowest migrations and recent batches to show")
+	rootCmd.AddCommand(statsCmd)
+}
+
+var statsCmd = &cobra.Command{
+	Use:     "stats",
+	Short:   "Show how long applied migrations took",
+	Long:    "The stats command shows how long the applied migrations took to run, as recorded in the migrations table by the up command: the total, the slowest migrations and the duration of the most recent batches. Migrations applied by versions of miflo that did not record durations are left out.",
+	Args:    cobra.NoArgs,
+	Example: "miflo stats\nmiflo stats --limit 20",
+	Run: func(cmd *cobra.Command, args []string) {
+		limit, _ := cmd.Flags().GetInt("limit")
+		if limit < 1 {
+			helpers.ErrAndExit("--limit must be at least 1")
+		}
+
+		ctx, stop := commandContext()
+		defer stop()
+
+		database, err := connectDatabase(ctx, cmd)
+		if err != nil {
+			helpers.ErrAndExit(err.Error())
+		}
+
+		defer database.Close()
+
+		stats, err := miflo.GetMigrationStats(database, ctx, limit)
+		if err != nil {
+			helpers.ErrAndExit(err.Error())
+		}
+
+		if stats.Timed < 1 {
+			fmt.Println("No migration durations recorded")
+			return
+		}
+
+		fmt.Printf("%d applied migration(s) took %s in total\n", stats.Timed, helpers.FormatDuration(stats.Total))
+
+		fmt.Println("\nSlowest migrations:")
+		for _, migration := range stats.Slowest {
+			fmt.Println(helpers.ColorYellow, helpers.FormatDuration(migration.Duration), migration.Name, fmt.Sprintf("(batch %d)", migration.Batch), helpers.ColorReset)
+		}
+
+		fmt.Println("\nRecent batches:")
+		for _, batch := range stats.Batches {
+			fmt.Printf(" batch %d: %d migration(s) in %s\n", batch.Batch, batch.Migrations, helpers.FormatDuration(batch.Duration))
+		}
+	},
+}
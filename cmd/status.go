@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gavsidhu/miflo/internal/helpers"
+	"github.com/gavsidhu/miflo/internal/miflo"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	statusCmd.Flags().Bool("all-schemas", false, "show the status of every PostgreSQL schema with a migrations table")
+	statusCmd.Flags().String("schemas", "", "with --all-schemas, only show the schemas matching this LIKE pattern, including schemas that have not been migrated yet")
+	rootCmd.AddCommand(statusCmd)
+}
+
+var statusCmd = &cobra.Command{
+	Use:     "status",
+	Short:   "Show the migration status of the database",
+	Long:    "The status command shows how many migrations have been applied to the database, the latest applied migration and how many are pending. With --all-schemas it shows the status of every PostgreSQL schema that holds its own migrations table, such as the schemas migrated with up --schemas, and highlights the schemas that are behind. The command exits with status 1 when a migration is dirty, in the database or in any of the schemas.",
+	Args:    cobra.NoArgs,
+	Example: "miflo status\nmiflo status --all-schemas\nmiflo status --all-schemas --schemas 'tenant_%'",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := commandContext()
+		defer stop()
+
+		database, err := connectDatabase(ctx, cmd)
+		if err != nil {
+			helpers.ErrAndExit(err.Error())
+		}
+
+		defer database.Close()
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			helpers.ErrAndExit(fmt.Sprint("error getting current working directory: ", err))
+		}
+
+		allSchemas, _ := cmd.Flags().GetBool("all-schemas")
+		if !allSchemas {
+			status, err := miflo.GetStatus(database, ctx, cwd)
+			if err != nil {
+				helpers.ErrAndExit(err.Error())
+			}
+
+			printStatus(status)
+			if len(status.Dirty) > 0 {
+				os.Exit(1)
+			}
+			return
+		}
+
+		pattern, _ := cmd.Flags().GetString("schemas")
+		statuses, err := miflo.GetSchemaStatuses(ctx, database, cwd, pattern)
+		if err != nil {
+			helpers.ErrAndExit(err.Error())
+		}
+
+		if len(statuses) < 1 {
+			fmt.Println("No schemas found")
+			return
+		}
+
+		behind, dirty := 0, 0
+		for _, status := range statuses {
+			if status.Behind() {
+				behind++
+			}
+			if len(status.Dirty) > 0 {
+				dirty++
+			}
+			printStatus(status)
+		}
+
+		fmt.Printf("%d schema(s) up to date, %d behind\n", len(statuses)-behind, behind)
+		if dirty > 0 {
+			helpers.ErrAndExit(fmt.Sprintf("%d schema(s) have a dirty migration, resolve them with miflo force", dirty))
+		}
+	},
+}
+
+func printStatus(status miflo.MigrationStatus) {
+	latest := status.Latest
+	if latest == "" {
+		latest = "none"
+	}
+
+	line := fmt.Sprintf("%d applied, latest %s, %d pending", status.Applied, latest, len(status.Pending))
+	if status.Schema != "" {
+		line = status.Schema + ": " + line
+	}
+
+	for _, migration := range status.Dirty {
+		if status.Schema != "" {
+			migration = status.Schema + ": " + migration
+		}
+		fmt.Println(helpers.ColorRed, migration, "is dirty, resolve it with miflo force", helpers.ColorReset)
+	}
+
+	if status.Behind() {
+		fmt.Println(helpers.ColorYellow, line, helpers.ColorReset)
+		return
+	}
+	fmt.Println(helpers.ColorGreen, line, helpers.ColorReset)
+}
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gavsidhu/miflo/internal/helpers"
+	"github.com/gavsidhu/miflo/internal/miflo"
+	"github.com/spf13/cobra"
+)
+
+func usesTargets(cmd *cobra.Command) bool {
+	return cmd.Flags().Changed("targets") || cmd.Flags().Changed("targets-query") || cmd.Flags().Changed("schemas") || os.Getenv("MIFLO_TARGETS") != ""
+}
+
+// readTargets returns the database URLs given by --targets, --targets-query,
+// --schemas or MIFLO_TARGETS.
+func readTargets(ctx context.Context, cmd *cobra.Command) ([]string, error) {
+	if pattern, _ := cmd.Flags().GetString("schemas"); pattern != "" {
+		db, err := connectDatabase(ctx, cmd)
+		if err != nil {
+			return nil, err
+		}
+
+		defer db.Close()
+
+		return miflo.SchemaTargets(ctx, db, os.Getenv("DATABASE_URL"), pattern)
+	}
+
+	if query, _ := cmd.Flags().GetString("targets-query"); query != "" {
+		db, err := connectDatabase(ctx, cmd)
+		if err != nil {
+			return nil, err
+		}
+
+		defer db.Close()
+
+		return miflo.QueryTargets(ctx, db, query)
+	}
+
+	var r io.Reader = strings.NewReader(os.Getenv("MIFLO_TARGETS"))
+	if targetsFile, _ := cmd.Flags().GetString("targets"); targetsFile == "-" {
+		r = os.Stdin
+	} else if targetsFile != "" {
+		file, err := os.Open(targetsFile)
+		if err != nil {
+			return nil, fmt.Errorf("error opening targets file: %w", err)
+		}
+
+		defer file.Close()
+		r = file
+	}
+
+	return miflo.ParseTargets(r)
+}
+
+func applyToTargets(cmd *cobra.Command) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		helpers.ErrAndExit(fmt.Sprint("error getting current working directory: ", err))
+	}
+
+	if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+		helpers.ErrAndExit("--dry-run cannot be used with multiple targets")
+	}
+
+	opts, err := upOptions(cmd)
+	if err != nil {
+		helpers.ErrAndExit(err.Error())
+	}
+
+	ctx, stop := runContext(cmd)
+	defer stop()
+
+	targets, err := readTargets(ctx, cmd)
+	if err != nil {
+		helpers.ErrAndExit(err.Error())
+	}
+
+	stateFile, _ := cmd.Flags().GetString("state-file")
+	if resume, _ := cmd.Flags().GetBool("resume"); resume {
+		targets, err = miflo.FailedTargets(stateFile, targets)
+		if err != nil {
+			helpers.ErrAndExit(err.Error())
+		}
+	}
+
+	if len(targets) < 1 {
+		fmt.Println("no targets to migrate")
+		return
+	}
+
+	metricsFile, err := openMetricsFile(cmd)
+	if err != nil {
+		helpers.ErrAndExit(err.Error())
+	}
+
+	notifications, err := openNotifications(cmd)
+	if err != nil {
+		helpers.ErrAndExit(err.Error())
+	}
+
+	opts = append(opts, metricsFile.options()...)
+	opts = append(opts, notifications.options()...)
+
+	parallel, _ := cmd.Flags().GetInt("parallel")
+	results := miflo.ApplyMigrationsToTargets(ctx, targets, cwd, parallel, opts...)
+	metricsFile.save()
+
+	if err := miflo.SaveTargetState(stateFile, results); err != nil {
+		fmt.Println(helpers.ColorYellow, "error saving targets state:", err, helpers.ColorReset)
+	}
+
+	failed := 0
+	fmt.Println("Summary:")
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+			fmt.Println(helpers.ColorRed, "failed", result.Target, fmt.Sprintf("(%s):", result.Duration.Round(time.Millisecond)), helpers.RedactSecrets(result.Err.Error()), helpers.ColorReset)
+			continue
+		}
+		fmt.Println(helpers.ColorGreen, "ok", result.Target, fmt.Sprintf("(%s)", result.Duration.Round(time.Millisecond)), helpers.ColorReset)
+	}
+
+	fmt.Printf("%d target(s) succeeded, %d failed\n", len(results)-failed, failed)
+
+	var runErr error
+	if failed > 0 {
+		runErr = fmt.Errorf("migrations failed on %d target(s), retry them with --resume", failed)
+	}
+
+	if err := errors.Join(runErr, notifications.send()); err != nil {
+		helpers.ErrAndExit(err.Error())
+	}
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/gavsidhu/miflo/internal/miflo"
+	"github.com/spf13/cobra"
+)
+
+// addTimeoutFlags adds the flags that limit how long migrations may run.
+func addTimeoutFlags(cmd *cobra.Command) {
+	cmd.Flags().Duration("timeout", 0, "roll back and stop if the whole run takes longer than this, such as 10m")
+	cmd.Flags().Duration("statement-timeout", 0, "cancel a migration that runs longer than this, unless its file sets -- miflo:statement-timeout")
+	cmd.Flags().Duration("lock-timeout", 0, "cancel a migration that waits for a lock longer than this, unless its file sets -- miflo:lock-timeout")
+}
+
+func timeoutOptions(cmd *cobra.Command) []miflo.Option {
+	var opts []miflo.Option
+	if timeout, _ := cmd.Flags().GetDuration("statement-timeout"); timeout > 0 {
+		opts = append(opts, miflo.WithStatementTimeout(timeout))
+	}
+	if timeout, _ := cmd.Flags().GetDuration("lock-timeout"); timeout > 0 {
+		opts = append(opts, miflo.WithLockTimeout(timeout))
+	}
+	return opts
+}
+
+// runContext returns the context of commandContext, limited by --timeout.
+func runContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	ctx, stop := commandContext()
+
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	if timeout <= 0 {
+		return ctx, stop
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
@@ -1,58 +1,125 @@
 package cmd
 
 import (
-	"context"
+	"errors"
 	"fmt"
 	"os"
+	"time"
 
-	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/helpers"
 	"github.com/gavsidhu/miflo/internal/miflo"
-	"github.com/joho/godotenv"
 	"github.com/spf13/cobra"
 )
 
 func init() {
+	upCmd.Flags().String("out-of-order", "", "what to do with pending migrations older than the latest applied one: error, warn or allow (default \"error\", or MIFLO_OUT_OF_ORDER)")
+	upCmd.Flags().Bool("dry-run", false, "print the rendered SQL of the pending migrations instead of applying them")
+	upCmd.Flags().String("targets", "", "file with the database URLs to migrate, one per line (- reads standard input, defaults to MIFLO_TARGETS)")
+	upCmd.Flags().String("targets-query", "", "SQL query run against DATABASE_URL that returns the database URLs to migrate")
+	upCmd.Flags().String("schemas", "", "apply the migrations to every PostgreSQL schema in DATABASE_URL matching this LIKE pattern, such as 'tenant_%'")
+	upCmd.Flags().Int("parallel", 4, "number of targets migrated at the same time")
+	upCmd.Flags().Bool("resume", false, "only migrate the targets that failed in the previous run")
+	upCmd.Flags().String("state-file", ".miflo-targets.json", "file that records the targets that failed, used by --resume")
+	addTimeoutFlags(upCmd)
+	addMetricsFlag(upCmd)
+	addNotifyFlags(upCmd)
 	rootCmd.AddCommand(upCmd)
 }
 
 var upCmd = &cobra.Command{
 	Use:     "up",
 	Short:   "Apply migrations",
-	Long:    "The up command applies all pending migrations in the migrations folder.",
+	Long:    "The up command applies all pending migrations in the migrations folder. Pending migrations that are older than the latest applied migration are rejected unless the out-of-order policy is set to warn or allow. With --targets, --targets-query or MIFLO_TARGETS the migrations are applied to many databases in parallel instead of DATABASE_URL. With --schemas they are applied to every matching PostgreSQL schema, each with its own migrations table.",
 	Args:    cobra.NoArgs,
-	Example: "miflo up",
+	Example: "miflo up\nmiflo up --targets targets.txt --parallel 8\nmiflo up --targets targets.txt --resume\nmiflo up --schemas 'tenant_%'\nmiflo up --lock-timeout 5s --statement-timeout 5m\nmiflo up --metrics-file /var/lib/node_exporter/miflo.prom",
 	Run: func(cmd *cobra.Command, args []string) {
-		err := godotenv.Load()
-		if err != nil {
-			fmt.Println("Error loading .env file")
+		if usesTargets(cmd) {
+			applyToTargets(cmd)
 			return
 		}
 
-		cwd, err := os.Getwd()
-		if err != nil {
-			fmt.Println("error getting current working directory: ", err)
+		if err := applyUp(cmd); err != nil {
+			helpers.ErrAndExit(err.Error())
 		}
+	},
+}
 
-		databaseConnection := os.Getenv("DATABASE_URL")
-		if databaseConnection == "" {
-			fmt.Println("DATABASE_URL is not set")
-			return
-		}
+// applyUp applies the pending migrations to DATABASE_URL. It returns the
+// error that failed the run once the metrics and notifications are written.
+func applyUp(cmd *cobra.Command) (err error) {
+	ctx, stop := runContext(cmd)
+	defer stop()
 
-		database, err := database.NewDatabase(databaseConnection)
-		if err != nil {
-			fmt.Println("Error setting up database:", err)
-			return
-		}
+	notifications, err := openNotifications(cmd)
+	if err != nil {
+		return err
+	}
 
-		defer database.Close()
+	defer func() {
+		err = errors.Join(err, notifications.send())
+	}()
 
-		ctx := context.Background()
+	metricsFile, err := openMetricsFile(cmd)
+	if err != nil {
+		return err
+	}
 
-		if err := miflo.ApplyMigrations(database, ctx, cwd); err != nil {
-			fmt.Println(err)
-			return
-		}
+	defer metricsFile.save()
 
-	},
+	start := time.Now()
+	database, err := connectDatabase(ctx, cmd)
+	if err != nil {
+		metricsFile.recordFailure(miflo.Up, start, err)
+		notifications.recordFailure(miflo.Up, start, err)
+		return err
+	}
+
+	defer database.Close()
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("error getting current working directory: %w", err)
+	}
+
+	opts, err := upOptions(cmd)
+	if err != nil {
+		return err
+	}
+
+	opts = append(opts, metricsFile.options()...)
+	opts = append(opts, notifications.options()...)
+	return miflo.ApplyMigrations(database, ctx, cwd, opts...)
+}
+
+func upOptions(cmd *cobra.Command) ([]miflo.Option, error) {
+	outOfOrder, _ := cmd.Flags().GetString("out-of-order")
+	if outOfOrder == "" {
+		outOfOrder = os.Getenv("MIFLO_OUT_OF_ORDER")
+	}
+	if outOfOrder == "" {
+		outOfOrder = string(miflo.OutOfOrderError)
+	}
+
+	outOfOrderPolicy, err := miflo.ParseOutOfOrderPolicy(outOfOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	vars, err := templateVars(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	retry, err := connectRetry(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []miflo.Option{miflo.WithOutOfOrderPolicy(outOfOrderPolicy), miflo.WithVars(vars), miflo.WithConnectRetry(retry)}
+	opts = append(opts, timeoutOptions(cmd)...)
+	if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+		opts = append(opts, miflo.WithDryRun(os.Stdout))
+	}
+
+	return opts, nil
 }
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/helpers"
+	"github.com/gavsidhu/miflo/internal/miflo"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+}
+
+var validateCmd = &cobra.Command{
+	Use:     "validate",
+	Short:   "Validate the migrations directory",
+	Long:    "The validate command checks the migrations directory for problems such as missing or empty SQL files, duplicate timestamps, stray files and applied migrations whose directories were deleted. It exits with a non-zero status if any problems other than warnings are found. The applied migrations check is skipped when DATABASE_URL is not set.",
+	Args:    cobra.NoArgs,
+	Example: "miflo validate",
+	Run: func(cmd *cobra.Command, args []string) {
+		cwd, err := os.Getwd()
+		if err != nil {
+			helpers.ErrAndExit(fmt.Sprint("error getting current working directory: ", err))
+		}
+
+		ctx, stop := commandContext()
+		defer stop()
+
+		var db database.Database
+		if os.Getenv("DATABASE_URL") != "" {
+			db, err = connectDatabase(ctx, cmd)
+			if err != nil {
+				helpers.ErrAndExit(err.Error())
+			}
+
+			defer db.Close()
+		}
+
+		problems, err := miflo.ValidateMigrations(db, ctx, cwd)
+		if err != nil {
+			helpers.ErrAndExit(fmt.Sprint("error validating migrations: ", err))
+		}
+
+		for _, problem := range problems {
+			if problem.Warning {
+				fmt.Println(helpers.ColorYellow, "warning:", problem, helpers.ColorReset)
+			}
+		}
+
+		errors := miflo.ValidationErrors(problems)
+		if len(errors) < 1 {
+			fmt.Println(helpers.ColorGreen, "Migrations directory is valid", helpers.ColorReset)
+			return
+		}
+
+		for _, problem := range errors {
+			fmt.Println(helpers.ColorRed, problem, helpers.ColorReset)
+		}
+
+		if db != nil {
+			db.Close()
+		}
+		helpers.ErrAndExit(fmt.Sprintf("found %d problem(s) in migrations directory", len(errors)))
+	},
+}
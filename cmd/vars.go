@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"github.com/gavsidhu/miflo/internal/miflo"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringArray("var", nil, "variable used in migration SQL as ${name}, given as name=value (can be repeated, overrides MIFLO_VAR_<name>)")
+}
+
+// templateVars returns the variables defined by MIFLO_VAR_ environment
+// variables, including those in the .env file, and the --var flags. It must be
+// called after the .env file is loaded.
+func templateVars(cmd *cobra.Command) (map[string]string, error) {
+	vars := miflo.VarsFromEnv()
+
+	definitions, _ := cmd.Flags().GetStringArray("var")
+	for _, definition := range definitions {
+		name, value, err := miflo.ParseVar(definition)
+		if err != nil {
+			return nil, err
+		}
+		vars[name] = value
+	}
+
+	return vars, nil
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/gavsidhu/miflo/internal/helpers"
+	"github.com/gavsidhu/miflo/internal/miflo"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of the wait command.
+const (
+	waitExitError       = 1
+	waitExitUnreachable = 2
+	waitExitPending     = 3
+)
+
+func init() {
+	waitCmd.Flags().Int("pending", -1, "also wait until at most this many migrations are pending, such as 0 for all applied")
+	waitCmd.Flags().Duration("timeout", time.Minute, "give up after this long, 0 waits forever")
+	waitCmd.Flags().Duration("interval", time.Second, "time between two checks")
+	rootCmd.AddCommand(waitCmd)
+}
+
+var waitCmd = &cobra.Command{
+	Use:   "wait",
+	Short: "Wait until the database is ready",
+	Long: `The wait command blocks until the database at DATABASE_URL is reachable and its migrations table can be set up, which makes it suitable for init containers and docker-compose services that start before the database. With --pending it also waits until at most that many migrations are pending, so an application can wait for another instance to run miflo up.
+
+The exit status is 0 when the database is ready, 2 when it was still not reachable at the deadline, 3 when migrations were still pending at the deadline and 1 for any other error.`,
+	Args:    cobra.NoArgs,
+	Example: "miflo wait\nmiflo wait --pending=0 --timeout 5m",
+	Run: func(cmd *cobra.Command, args []string) {
+		databaseConnection := os.Getenv("DATABASE_URL")
+		if databaseConnection == "" {
+			helpers.ErrAndExit("DATABASE_URL is not set")
+		}
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			helpers.ErrAndExit(fmt.Sprint("error getting current working directory: ", err))
+		}
+
+		opts := miflo.WaitOptions{OnWait: func(reason string) {
+			slog.Info(reason)
+		}}
+		opts.MaxPending, _ = cmd.Flags().GetInt("pending")
+		opts.Interval, _ = cmd.Flags().GetDuration("interval")
+
+		ctx, stop := commandContext()
+		defer stop()
+
+		if timeout, _ := cmd.Flags().GetDuration("timeout"); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		err = miflo.WaitForDatabase(ctx, databaseConnection, cwd, opts)
+		switch {
+		case err == nil:
+			fmt.Println(helpers.ColorGreen, "Database is ready", helpers.ColorReset)
+		case errors.Is(err, miflo.ErrNotReachable):
+			fmt.Fprintln(os.Stderr, helpers.RedactSecrets(err.Error()))
+			os.Exit(waitExitUnreachable)
+		case errors.Is(err, miflo.ErrStillPending):
+			fmt.Fprintln(os.Stderr, helpers.RedactSecrets(err.Error()))
+			os.Exit(waitExitPending)
+		default:
+			fmt.Fprintln(os.Stderr, helpers.RedactSecrets(err.Error()))
+			os.Exit(waitExitError)
+		}
+	},
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Retry controls how Connect retries while the database is not reachable.
+// The zero value does not retry.
+type Retry struct {
+	// Attempts is the number of retries after the first attempt. A negative
+	// number retries until the context is done.
+	Attempts int
+	// Delay is the wait before the first retry. It doubles after every
+	// retry up to MaxDelay.
+	Delay    time.Duration
+	MaxDelay time.Duration
+}
+
+// Defaults used for the fields of Retry that are not set.
+const (
+	DefaultRetryDelay    = time.Second
+	DefaultMaxRetryDelay = 30 * time.Second
+)
+
+// Backoff returns the wait before the nth retry, starting at 1.
+func (r Retry) Backoff(n int) time.Duration {
+	maxDelay := r.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = DefaultMaxRetryDelay
+	}
+
+	delay := r.Delay
+	if delay <= 0 {
+		delay = DefaultRetryDelay
+	}
+	for i := 1; i < n && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
+
+// Connect calls NewDatabase, retrying with exponential backoff as long as
+// retry allows. onRetry, if not nil, is called before every wait. URLs that
+// cannot be parsed or have an unsupported scheme are not retried.
+func Connect(ctx context.Context, databaseURL string, retry Retry, onRetry func(attempt int, delay time.Duration, err error), opts ...Option) (Database, error) {
+	if _, err := dialectFor(databaseURL); err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		db, err := NewDatabase(databaseURL, opts...)
+		if err == nil {
+			return db, nil
+		}
+
+		if retry.Attempts >= 0 && attempt > retry.Attempts {
+			return nil, err
+		}
+
+		delay := retry.Backoff(attempt)
+		if onRetry != nil {
+			onRetry(attempt, delay, err)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%w (gave up waiting: %v)", err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"log/slog"
 	"net/url"
-	"strings"
+	"time"
+
+	"github.com/gavsidhu/miflo/internal/helpers"
 
 	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
@@ -13,67 +16,185 @@ import (
 )
 
 type Database interface {
-	ApplyMigration(ctx context.Context, tx *sql.Tx, migrationName string, cwd string) error
-	RecordMigration(ctx context.Context, tx *sql.Tx, migrationName string, batchNum int) error
-	RevertMigration(ctx context.Context, tx *sql.Tx, migrationName string, cwd string) error
-	DeleteMigration(ctx context.Context, tx *sql.Tx, batchNum int) error
+	ApplyMigration(ctx context.Context, tx *sql.Tx, migrationName string, query string) error
+	// RecordMigration records migrationName as applied in batchNum, with the
+	// checksum of its up SQL and how long that SQL took to run.
+	RecordMigration(ctx context.Context, tx *sql.Tx, migrationName string, batchNum int, checksum string, duration time.Duration) error
+	RevertMigration(ctx context.Context, tx *sql.Tx, migrationName string, query string) error
+	DeleteMigration(ctx context.Context, tx *sql.Tx, migrationName string) error
+	// MarkDirty records, outside of any transaction, that migrationName is
+	// about to be applied or reverted, as direction is up or down, without
+	// the protection of a transaction, so a failure halfway is not lost.
+	MarkDirty(ctx context.Context, migrationName string, batchNum int, direction string) error
+	GetDirtyMigrations(ctx context.Context) ([]string, error)
+	// DirtyDirection returns the direction dirty migrationName failed in.
+	DirtyDirection(ctx context.Context, migrationName string) (string, error)
+	// ResolveDirtyMigration clears the dirty state of migrationName once the
+	// up or down SQL it failed in is known to have completed or to have left
+	// no changes behind. Completed up SQL records it as applied with
+	// checksum and completed down SQL forgets it, otherwise the migration is
+	// recorded as it was before it ran.
+	ResolveDirtyMigration(ctx context.Context, migrationName string, completed bool, checksum string) error
 	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
-	GetNextBatchNumber() (int, error)
-	GetLastBatchNumber() (int, error)
-	GetAppliedMigrations() (*sql.Rows, error)
-	GetUnappliedMigrations(cwd string) ([]string, error)
-	GetMigrationsToRevert(batch int) ([]string, error)
+	GetNextBatchNumber(ctx context.Context) (int, error)
+	GetLastBatchNumber(ctx context.Context) (int, error)
+	GetAppliedMigrations(ctx context.Context) (*sql.Rows, error)
+	// GetAppliedChecksums returns the checksum of the up SQL each applied
+	// migration was applied with, keyed by name. Migrations recorded without
+	// a checksum are left out.
+	GetAppliedChecksums(ctx context.Context) (map[string]string, error)
+	// GetAppliedBatches returns the batch each applied migration was applied
+	// in, keyed by name.
+	GetAppliedBatches(ctx context.Context) (map[string]int, error)
+	// GetMigrationDurations returns the applied migrations that were recorded
+	// with a duration.
+	GetMigrationDurations(ctx context.Context) ([]MigrationDuration, error)
+	GetUnappliedMigrations(ctx context.Context, cwd string) ([]string, error)
+	GetMigrationsToRevert(ctx context.Context, batch int) ([]string, error)
+	ReplaceMigrations(ctx context.Context, tx *sql.Tx, replaced []string, migrationName string) error
+	GetRepeatableChecksums(ctx context.Context) (map[string]string, error)
+	RecordRepeatable(ctx context.Context, tx *sql.Tx, name string, checksum string) error
+	GetAppliedSeeds(ctx context.Context) ([]string, error)
+	RecordSeed(ctx context.Context, tx *sql.Tx, name string) error
+	Introspect(ctx context.Context) (*Schema, error)
+	// Lock blocks other miflo processes from changing the database until
+	// the returned function is called.
+	Lock(ctx context.Context) (func() error, error)
+	Dialect() Dialect
 	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
 	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
 	Close() error
 }
 
-func NewDatabase(databaseURL string) (Database, error) {
+// Dialect holds everything that differs between database engines. Each
+// engine defines its dialect in its own file and registers it for the URL
+// schemes it handles.
+type Dialect interface {
+	// Name identifies the dialect: "sqlite", "postgres" or "libsql".
+	Name() string
+	DriverName() string
+	// DataSourceName converts a miflo database URL into the data source name
+	// expected by the driver.
+	DataSourceName(databaseURL string) string
+	// Placeholder returns the bind parameter for the nth argument, starting
+	// at 1.
+	Placeholder(n int) string
+	MigrationsTableSQL() string
+	Introspect(ctx context.Context, db Querier) (*Schema, error)
+	// TransactionalDDL reports whether schema changes are rolled back with
+	// the transaction they ran in.
+	TransactionalDDL() bool
+	// SetTimeouts limits the statements that run in tx with ctx until it is
+	// called again. It returns the context those statements must run with.
+	// Zero timeouts restore the defaults.
+	SetTimeouts(ctx context.Context, tx *sql.Tx, timeouts Timeouts) (context.Context, context.CancelFunc, error)
+	// Lock blocks other miflo processes from changing the database until
+	// the returned function is called.
+	Lock(ctx context.Context, db *sql.DB) (func() error, error)
+}
+
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+var dialects = make(map[string]Dialect)
+
+func registerDialect(dialect Dialect, schemes ...string) {
+	for _, scheme := range schemes {
+		dialects[scheme] = dialect
+	}
+}
+
+func dialectFor(databaseURL string) (Dialect, error) {
 	u, err := url.Parse(databaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing database URL: %w", err)
 	}
 
-	switch u.Scheme {
-	case "sqlite":
-		path := strings.TrimPrefix(databaseURL, "sqlite:")
-		db, err := sql.Open("sqlite3", path)
-		if err != nil {
-			return nil, fmt.Errorf("error opening SQLite database: %w", err)
-		}
+	dialect, ok := dialects[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database type: %s", u.Scheme)
+	}
 
-		sqliteDB := &SQLiteDB{db}
-		if err := sqliteDB.ensureMigrationsTable(); err != nil {
-			return nil, fmt.Errorf("error setting up SQLite migrations table: %w", err)
-		}
-		return sqliteDB, nil
+	return dialect, nil
+}
 
-	case "postgresql", "postgres":
-		db, err := sql.Open("postgres", databaseURL)
-		if err != nil {
-			return nil, fmt.Errorf("error opening PostgreSQL database: %w", err)
-		}
+// DialectName returns the SQL dialect NewDatabase would use for databaseURL
+// without connecting to it: "sqlite", "postgres" or "libsql".
+func DialectName(databaseURL string) (string, error) {
+	dialect, err := dialectFor(databaseURL)
+	if err != nil {
+		return "", err
+	}
 
-		postgresDB := &PostgresDB{db}
-		if err := postgresDB.ensureMigrationsTable(); err != nil {
-			return nil, fmt.Errorf("error setting up PostgreSQL migrations table: %w", err)
-		}
-		return postgresDB, nil
+	return dialect.Name(), nil
+}
 
-	case "libsql", "http":
-		db, err := sql.Open("libsql", databaseURL)
-		if err != nil {
-			return nil, fmt.Errorf("error opening libSQL database: %w", err)
+// LookupDialect returns the dialect called name: "sqlite", "postgres" or
+// "libsql".
+func LookupDialect(name string) (Dialect, error) {
+	for _, dialect := range dialects {
+		if dialect.Name() == name {
+			return dialect, nil
 		}
+	}
 
-		libSQLDB := &libSQLDB{db}
-		if err := libSQLDB.ensureMigrationsTable(); err != nil {
-			return nil, fmt.Errorf("error setting up libSQL migrations table: %w", err)
-		}
-		return libSQLDB, nil
+	return nil, fmt.Errorf("unsupported dialect %q, expected sqlite, postgres or libsql", name)
+}
 
-	default:
-		return nil, fmt.Errorf("unsupported database type: %s", u.Scheme)
+// Option configures NewDatabase.
+type Option func(*sqlDatabase)
+
+// WithLogger makes the database log the statements it executes to logger
+// instead of slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(db *sqlDatabase) {
+		db.logger = logger
+	}
+}
+
+func NewDatabase(databaseURL string, opts ...Option) (Database, error) {
+	dialect, err := dialectFor(databaseURL)
+	if err != nil {
+		return nil, err
 	}
 
+	db, err := sql.Open(dialect.DriverName(), dialect.DataSourceName(databaseURL))
+	if err != nil {
+		return nil, redactError(fmt.Errorf("error opening %s database: %w", dialect.Name(), err))
+	}
+
+	return newSQLDatabase(db, dialect, opts...)
+}
+
+func newSQLDatabase(db *sql.DB, dialect Dialect, opts ...Option) (Database, error) {
+	sqlDB := &sqlDatabase{DB: db, dialect: dialect, logger: slog.Default()}
+	for _, opt := range opts {
+		opt(sqlDB)
+	}
+
+	if err := sqlDB.ensureMigrationsTable(); err != nil {
+		db.Close()
+		return nil, redactError(fmt.Errorf("error setting up %s migrations table: %w", dialect.Name(), err))
+	}
+
+	return sqlDB, nil
+}
+
+// redactedError hides the connection strings in the message of an error,
+// which drivers may include when they fail to connect.
+type redactedError struct {
+	err error
+}
+
+func redactError(err error) error {
+	return &redactedError{err: err}
+}
+
+func (e *redactedError) Error() string {
+	return helpers.RedactSecrets(e.err.Error())
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
 }
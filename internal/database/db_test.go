@@ -1,13 +1,20 @@
 package database
 
 import (
+	"context"
+	"database/sql"
+	"log/slog"
 	"os"
+	"os/exec"
 	"path"
+	"path/filepath"
 	"testing"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	_ "github.com/tursodatabase/libsql-client-go/libsql"
 )
 
@@ -54,3 +61,180 @@ func TestNewDatabase(t *testing.T) {
 		}
 	})
 }
+
+func openSQLite(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestPlaceholders(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{sqliteDialect{}, "?, ?, ?"},
+		{libSQLDialect{}, "?, ?, ?"},
+		{postgresDialect{}, "$2, $3, $4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dialect.Name(), func(t *testing.T) {
+			db := &sqlDatabase{dialect: tt.dialect}
+			assert.Equal(t, tt.want, db.placeholders(2, 3))
+		})
+	}
+}
+
+func TestSQLiteLock(t *testing.T) {
+	ctx := context.Background()
+	dialect := sqliteDialect{}
+	host, _ := os.Hostname()
+
+	t.Run("Exclusive", func(t *testing.T) {
+		db := openSQLite(t)
+
+		release, err := dialect.Lock(ctx, db)
+		require.NoError(t, err)
+
+		_, err = dialect.Lock(ctx, db)
+		assert.ErrorContains(t, err, "database is locked by miflo process")
+
+		require.NoError(t, release())
+
+		release, err = dialect.Lock(ctx, db)
+		require.NoError(t, err)
+		require.NoError(t, release())
+	})
+
+	t.Run("StaleLock", func(t *testing.T) {
+		db := openSQLite(t)
+
+		stopped := exec.Command("go", "version")
+		require.NoError(t, stopped.Run())
+
+		release, err := dialect.Lock(ctx, db)
+		require.NoError(t, err)
+		_, err = db.Exec("UPDATE miflo_lock SET pid = ?", stopped.Process.Pid)
+		require.NoError(t, err)
+
+		release, err = dialect.Lock(ctx, db)
+		require.NoError(t, err)
+
+		var pid int
+		require.NoError(t, db.QueryRow("SELECT pid FROM miflo_lock").Scan(&pid))
+		assert.Equal(t, os.Getpid(), pid)
+
+		require.NoError(t, release())
+	})
+
+	t.Run("OtherHost", func(t *testing.T) {
+		db := openSQLite(t)
+
+		_, err := dialect.Lock(ctx, db)
+		require.NoError(t, err)
+		_, err = db.Exec("UPDATE miflo_lock SET host = ?", host+"-other")
+		require.NoError(t, err)
+
+		_, err = dialect.Lock(ctx, db)
+		assert.ErrorContains(t, err, "on "+host+"-other")
+	})
+
+	t.Run("OldLockTable", func(t *testing.T) {
+		db := openSQLite(t)
+
+		_, err := db.Exec(`CREATE TABLE miflo_lock (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			locked_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`)
+		require.NoError(t, err)
+
+		release, err := dialect.Lock(ctx, db)
+		require.NoError(t, err)
+
+		var lockHost string
+		require.NoError(t, db.QueryRow("SELECT host FROM miflo_lock").Scan(&lockHost))
+		assert.Equal(t, host, lockHost)
+
+		require.NoError(t, release())
+	})
+}
+
+func TestEnsureMigrationsTable(t *testing.T) {
+	db := openSQLite(t)
+
+	_, err := db.Exec(`CREATE TABLE miflo_migrations (
+		id INTEGER PRIMARY KEY,
+		name TEXT UNIQUE NOT NULL,
+		batch INTEGER NOT NULL,
+		applied BOOLEAN NOT NULL,
+		applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO miflo_migrations (name, batch, applied) VALUES ('1_create_users', 1, TRUE)")
+	require.NoError(t, err)
+
+	sqlDB := &sqlDatabase{DB: db, dialect: sqliteDialect{}}
+	require.NoError(t, sqlDB.ensureMigrationsTable())
+	// Running it on an up to date table changes nothing.
+	require.NoError(t, sqlDB.ensureMigrationsTable())
+
+	var checksum sql.NullString
+	var dirty bool
+	var duration sql.NullInt64
+	require.NoError(t, db.QueryRow("SELECT checksum, dirty, duration_ms FROM miflo_migrations WHERE name = '1_create_users'").Scan(&checksum, &dirty, &duration))
+	assert.False(t, checksum.Valid)
+	assert.False(t, dirty)
+	assert.False(t, duration.Valid)
+
+	for _, table := range []string{"miflo_repeatable", "miflo_seeds"} {
+		_, err := db.Exec("SELECT name FROM " + table)
+		assert.NoError(t, err, table)
+	}
+}
+
+func TestDirtyDirection(t *testing.T) {
+	ctx := context.Background()
+	db := &sqlDatabase{DB: openSQLite(t), dialect: sqliteDialect{}, logger: slog.Default()}
+	require.NoError(t, db.ensureMigrationsTable())
+
+	require.NoError(t, db.MarkDirty(ctx, "1_create_users", 1, "up"))
+	_, err := db.Exec("INSERT INTO miflo_migrations (name, batch, applied, checksum) VALUES ('2_create_posts', 1, TRUE, 'abc')")
+	require.NoError(t, err)
+	require.NoError(t, db.MarkDirty(ctx, "2_create_posts", 1, "down"))
+	// Rows marked dirty before the direction was recorded.
+	_, err = db.Exec("INSERT INTO miflo_migrations (name, batch, applied, dirty) VALUES ('3_old_up', 1, FALSE, TRUE), ('4_old_down', 1, TRUE, TRUE)")
+	require.NoError(t, err)
+
+	for name, want := range map[string]string{"1_create_users": "up", "2_create_posts": "down", "3_old_up": "up", "4_old_down": "down"} {
+		direction, err := db.DirtyDirection(ctx, name)
+		require.NoError(t, err)
+		assert.Equal(t, want, direction, name)
+	}
+
+	_, err = db.DirtyDirection(ctx, "5_missing")
+	assert.EqualError(t, err, "migration 5_missing is not dirty")
+
+	// A revert that left no changes behind keeps the migration applied.
+	require.NoError(t, db.ResolveDirtyMigration(ctx, "2_create_posts", false, ""))
+	var checksum string
+	require.NoError(t, db.QueryRow("SELECT checksum FROM miflo_migrations WHERE name = '2_create_posts' AND applied = TRUE AND dirty = FALSE AND direction IS NULL").Scan(&checksum))
+	assert.Equal(t, "abc", checksum)
+
+	// A revert that completed forgets it.
+	require.NoError(t, db.ResolveDirtyMigration(ctx, "4_old_down", true, ""))
+	require.NoError(t, db.ResolveDirtyMigration(ctx, "1_create_users", true, "def"))
+	require.NoError(t, db.ResolveDirtyMigration(ctx, "3_old_up", false, ""))
+
+	var names []string
+	rows, err := db.Query("SELECT name || ' ' || applied FROM miflo_migrations ORDER BY name")
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		require.NoError(t, rows.Scan(&name))
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"1_create_users 1", "2_create_posts 1"}, names)
+}
@@ -0,0 +1,527 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gavsidhu/miflo/internal/helpers"
+	"github.com/gavsidhu/miflo/internal/logging"
+)
+
+// repeatableTableSQL creates the table that tracks the checksum each
+// repeatable migration was last applied with. It is the same for every
+// dialect.
+const repeatableTableSQL = `
+    CREATE TABLE IF NOT EXISTS miflo_repeatable (
+        name VARCHAR(255) PRIMARY KEY,
+        checksum VARCHAR(64) NOT NULL,
+        applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );`
+
+// seedsTableSQL creates the table that tracks which seeds have run. It is the
+// same for every dialect.
+const seedsTableSQL = `
+    CREATE TABLE IF NOT EXISTS miflo_seeds (
+        name VARCHAR(255) PRIMARY KEY,
+        applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );`
+
+// sqlDatabase implements Database on top of database/sql for any dialect.
+type sqlDatabase struct {
+	*sql.DB
+	dialect Dialect
+	logger  *slog.Logger
+}
+
+func (db *sqlDatabase) Dialect() Dialect {
+	return db.dialect
+}
+
+// placeholders returns count bind parameters starting at the nth argument.
+func (db *sqlDatabase) placeholders(n int, count int) string {
+	params := make([]string, count)
+	for i := range params {
+		params[i] = db.dialect.Placeholder(n + i)
+	}
+	return strings.Join(params, ", ")
+}
+
+// trace logs a statement miflo runs on its own tables.
+func (db *sqlDatabase) trace(ctx context.Context, query string, args []any) {
+	db.logger.Log(ctx, logging.LevelTrace, "executing statement", "sql", strings.TrimSpace(query), "args", args)
+}
+
+// exec runs a statement on the miflo tables, in tx if it is not nil.
+func (db *sqlDatabase) exec(ctx context.Context, tx *sql.Tx, query string, args ...any) (sql.Result, error) {
+	db.trace(ctx, query, args)
+	if tx != nil {
+		return tx.ExecContext(ctx, query, args...)
+	}
+	return db.DB.ExecContext(ctx, query, args...)
+}
+
+func (db *sqlDatabase) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	db.trace(ctx, query, args)
+	return db.DB.QueryContext(ctx, query, args...)
+}
+
+func (db *sqlDatabase) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	db.trace(ctx, query, args)
+	return db.DB.QueryRowContext(ctx, query, args...)
+}
+
+// ApplyMigration executes query, the rendered up SQL of migrationName.
+func (db *sqlDatabase) ApplyMigration(ctx context.Context, tx *sql.Tx, migrationName string, query string) error {
+	db.logger.DebugContext(ctx, "executing statement", "migration", migrationName, "sql", strings.TrimSpace(query))
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("error executing migration %s: %w", migrationName, err)
+	}
+
+	return nil
+}
+
+func (db *sqlDatabase) RecordMigration(ctx context.Context, tx *sql.Tx, migrationName string, batchNum int, checksum string, duration time.Duration) error {
+	query := fmt.Sprintf("INSERT INTO miflo_migrations (name, batch, applied, checksum, duration_ms) VALUES (%s)", db.placeholders(1, 5))
+	if _, err := db.exec(ctx, tx, query, migrationName, batchNum, true, checksum, duration.Milliseconds()); err != nil {
+		return fmt.Errorf("error executing migration row insert: %w", err)
+	}
+
+	return nil
+}
+
+// RevertMigration executes query, the rendered down SQL of migrationName.
+func (db *sqlDatabase) RevertMigration(ctx context.Context, tx *sql.Tx, migrationName string, query string) error {
+	db.logger.DebugContext(ctx, "executing statement", "migration", migrationName, "sql", strings.TrimSpace(query))
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("error reverting migration %s: %w", migrationName, err)
+	}
+
+	return nil
+}
+
+func (db *sqlDatabase) DeleteMigration(ctx context.Context, tx *sql.Tx, migrationName string) error {
+	query := fmt.Sprintf("DELETE FROM miflo_migrations WHERE name = %s", db.dialect.Placeholder(1))
+	if _, err := db.exec(ctx, tx, query, migrationName); err != nil {
+		return fmt.Errorf("error executing migration row delete: %w", err)
+	}
+
+	return nil
+}
+
+// MarkDirty flags the row of migrationName as dirty while it runs in
+// direction, inserting an unapplied row if the migration has not been
+// applied yet.
+func (db *sqlDatabase) MarkDirty(ctx context.Context, migrationName string, batchNum int, direction string) error {
+	update := fmt.Sprintf("UPDATE miflo_migrations SET dirty = TRUE, direction = %s WHERE name = %s", db.dialect.Placeholder(1), db.dialect.Placeholder(2))
+	result, err := db.exec(ctx, nil, update, direction, migrationName)
+	if err != nil {
+		return fmt.Errorf("error marking migration %s as dirty: %w", migrationName, err)
+	}
+
+	if updated, err := result.RowsAffected(); err == nil && updated > 0 {
+		return nil
+	}
+
+	insert := fmt.Sprintf("INSERT INTO miflo_migrations (name, batch, applied, dirty, direction) VALUES (%s, FALSE, TRUE, %s)", db.placeholders(1, 2), db.dialect.Placeholder(3))
+	if _, err := db.exec(ctx, nil, insert, migrationName, batchNum, direction); err != nil {
+		return fmt.Errorf("error marking migration %s as dirty: %w", migrationName, err)
+	}
+
+	return nil
+}
+
+// DirtyDirection returns the direction, up or down, dirty migrationName
+// failed in. Rows marked dirty by older versions of miflo have no direction,
+// and were being reverted when the migration was already applied.
+func (db *sqlDatabase) DirtyDirection(ctx context.Context, migrationName string) (string, error) {
+	query := fmt.Sprintf("SELECT COALESCE(direction, CASE WHEN applied THEN 'down' ELSE 'up' END) FROM miflo_migrations WHERE name = %s AND dirty = TRUE", db.dialect.Placeholder(1))
+
+	var direction string
+	if err := db.QueryRowContext(ctx, query, migrationName).Scan(&direction); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("migration %s is not dirty", migrationName)
+		}
+		return "", fmt.Errorf("error querying dirty migration %s: %w", migrationName, err)
+	}
+
+	return direction, nil
+}
+
+// GetDirtyMigrations returns the migrations that failed halfway outside of
+// a transaction.
+func (db *sqlDatabase) GetDirtyMigrations(ctx context.Context) ([]string, error) {
+	var migrations []string
+	err := queryEach(ctx, db, "SELECT name FROM miflo_migrations WHERE dirty = TRUE ORDER BY name", func(rows *sql.Rows) error {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		migrations = append(migrations, name)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error querying dirty migrations: %w", err)
+	}
+
+	return migrations, nil
+}
+
+// ResolveDirtyMigration records how dirty migrationName ended. A migration
+// that failed while being applied is recorded as applied with checksum if it
+// completed and forgotten otherwise. One that failed while being reverted is
+// forgotten if the revert completed and kept applied otherwise.
+func (db *sqlDatabase) ResolveDirtyMigration(ctx context.Context, migrationName string, completed bool, checksum string) error {
+	direction, err := db.DirtyDirection(ctx, migrationName)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("DELETE FROM miflo_migrations WHERE name = %s AND dirty = TRUE", db.dialect.Placeholder(1))
+	args := []any{migrationName}
+	switch {
+	case direction == "up" && completed:
+		query = fmt.Sprintf("UPDATE miflo_migrations SET applied = TRUE, dirty = FALSE, direction = NULL, checksum = %s WHERE name = %s AND dirty = TRUE",
+			db.dialect.Placeholder(1), db.dialect.Placeholder(2))
+		args = []any{checksum, migrationName}
+	case direction == "down" && !completed:
+		query = fmt.Sprintf("UPDATE miflo_migrations SET dirty = FALSE, direction = NULL WHERE name = %s AND dirty = TRUE", db.dialect.Placeholder(1))
+	}
+
+	result, err := db.exec(ctx, nil, query, args...)
+	if err != nil {
+		return fmt.Errorf("error resolving dirty migration %s: %w", migrationName, err)
+	}
+
+	if resolved, err := result.RowsAffected(); err == nil && resolved < 1 {
+		return fmt.Errorf("migration %s is not dirty", migrationName)
+	}
+
+	return nil
+}
+
+func (db *sqlDatabase) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return db.DB.BeginTx(ctx, opts)
+}
+
+func (db *sqlDatabase) Close() error {
+	return db.DB.Close()
+}
+
+func (db *sqlDatabase) GetUnappliedMigrations(ctx context.Context, cwd string) ([]string, error) {
+	dirMigrations, err := helpers.GetDirMigrations(cwd)
+	if err != nil {
+		return nil, err
+	}
+
+	appliedMigrationsRows, err := db.GetAppliedMigrations(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting applied migrations: %w", err)
+	}
+
+	defer appliedMigrationsRows.Close()
+
+	appliedMigrations, err := helpers.GetAppliedMigrationNames(appliedMigrationsRows)
+	if err != nil {
+		return nil, err
+	}
+
+	var pendingMigrations []string
+	for _, migration := range dirMigrations {
+		if !helpers.Contains(appliedMigrations, migration) {
+			pendingMigrations = append(pendingMigrations, migration)
+		}
+	}
+
+	return pendingMigrations, nil
+}
+
+func (db *sqlDatabase) GetAppliedMigrations(ctx context.Context) (*sql.Rows, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM miflo_migrations WHERE applied = TRUE")
+	if err != nil {
+		return nil, fmt.Errorf("error querying for applied migrations: %w", err)
+	}
+
+	return rows, nil
+}
+
+func (db *sqlDatabase) GetMigrationsToRevert(ctx context.Context, batch int) ([]string, error) {
+	appliedMigrationsByBatch, err := db.GetAppliedMigrationsByBatch(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+
+	if appliedMigrationsByBatch == nil {
+		return nil, errors.New("no applied migrations found")
+	}
+
+	defer appliedMigrationsByBatch.Close()
+
+	var migrationsToRevert []string
+	for appliedMigrationsByBatch.Next() {
+		var name string
+		if err := appliedMigrationsByBatch.Scan(&name); err != nil {
+			return nil, err
+		}
+		migrationsToRevert = append(migrationsToRevert, name)
+	}
+
+	if err := appliedMigrationsByBatch.Err(); err != nil {
+		return nil, err
+	}
+
+	return migrationsToRevert, nil
+}
+
+func (db *sqlDatabase) GetAppliedChecksums(ctx context.Context) (map[string]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name, checksum FROM miflo_migrations WHERE applied = TRUE AND checksum IS NOT NULL")
+	if err != nil {
+		return nil, fmt.Errorf("error querying for applied migration checksums: %w", err)
+	}
+
+	defer rows.Close()
+
+	checksums := make(map[string]string)
+	for rows.Next() {
+		var name, checksum string
+		if err := rows.Scan(&name, &checksum); err != nil {
+			return nil, err
+		}
+		if checksum != "" {
+			checksums[name] = checksum
+		}
+	}
+
+	return checksums, rows.Err()
+}
+
+func (db *sqlDatabase) GetAppliedBatches(ctx context.Context) (map[string]int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name, batch FROM miflo_migrations WHERE applied = TRUE")
+	if err != nil {
+		return nil, fmt.Errorf("error querying for applied migration batches: %w", err)
+	}
+
+	defer rows.Close()
+
+	batches := make(map[string]int)
+	for rows.Next() {
+		var name string
+		var batch int
+		if err := rows.Scan(&name, &batch); err != nil {
+			return nil, err
+		}
+		batches[name] = batch
+	}
+
+	return batches, rows.Err()
+}
+
+// MigrationDuration is how long the up SQL of an applied migration took to
+// run.
+type MigrationDuration struct {
+	Name     string
+	Batch    int
+	Duration time.Duration
+}
+
+func (db *sqlDatabase) GetMigrationDurations(ctx context.Context) ([]MigrationDuration, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name, batch, duration_ms FROM miflo_migrations WHERE applied = TRUE AND duration_ms IS NOT NULL")
+	if err != nil {
+		return nil, fmt.Errorf("error querying for migration durations: %w", err)
+	}
+
+	defer rows.Close()
+
+	var durations []MigrationDuration
+	for rows.Next() {
+		var migration MigrationDuration
+		var milliseconds int64
+		if err := rows.Scan(&migration.Name, &migration.Batch, &milliseconds); err != nil {
+			return nil, err
+		}
+		migration.Duration = time.Duration(milliseconds) * time.Millisecond
+		durations = append(durations, migration)
+	}
+
+	return durations, rows.Err()
+}
+
+func (db *sqlDatabase) GetAppliedMigrationsByBatch(ctx context.Context, batch int) (*sql.Rows, error) {
+	query := fmt.Sprintf("SELECT name FROM miflo_migrations WHERE applied = TRUE AND batch = %s", db.dialect.Placeholder(1))
+	rows, err := db.QueryContext(ctx, query, batch)
+	if err != nil {
+		return nil, fmt.Errorf("error querying for applied migrations of batch %d: %w", batch, err)
+	}
+
+	return rows, nil
+}
+
+// ReplaceMigrations records migrationName as applied in place of the replaced
+// migrations. It is recorded in batch 0, before any batch up applies, so
+// reverting batches never reaches the baseline that replaced them.
+func (db *sqlDatabase) ReplaceMigrations(ctx context.Context, tx *sql.Tx, replaced []string, migrationName string) error {
+	if len(replaced) < 1 {
+		return nil
+	}
+
+	insert := fmt.Sprintf("INSERT INTO miflo_migrations (name, batch, applied) VALUES (%s, 0, TRUE)", db.dialect.Placeholder(1))
+	if _, err := db.exec(ctx, tx, insert, migrationName); err != nil {
+		return fmt.Errorf("error executing migration row insert: %w", err)
+	}
+
+	args := make([]any, len(replaced))
+	for i, name := range replaced {
+		args[i] = name
+	}
+
+	deleteQuery := fmt.Sprintf("DELETE FROM miflo_migrations WHERE name IN (%s)", db.placeholders(1, len(replaced)))
+	if _, err := db.exec(ctx, tx, deleteQuery, args...); err != nil {
+		return fmt.Errorf("error executing migration row delete: %w", err)
+	}
+
+	return nil
+}
+
+// GetRepeatableChecksums returns the checksum each repeatable migration was
+// last applied with, keyed by name.
+func (db *sqlDatabase) GetRepeatableChecksums(ctx context.Context) (map[string]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name, checksum FROM miflo_repeatable")
+	if err != nil {
+		return nil, fmt.Errorf("error querying for repeatable migrations: %w", err)
+	}
+
+	defer rows.Close()
+
+	checksums := make(map[string]string)
+	for rows.Next() {
+		var name, checksum string
+		if err := rows.Scan(&name, &checksum); err != nil {
+			return nil, err
+		}
+		checksums[name] = checksum
+	}
+
+	return checksums, rows.Err()
+}
+
+func (db *sqlDatabase) RecordRepeatable(ctx context.Context, tx *sql.Tx, name string, checksum string) error {
+	deleteQuery := fmt.Sprintf("DELETE FROM miflo_repeatable WHERE name = %s", db.dialect.Placeholder(1))
+	if _, err := db.exec(ctx, tx, deleteQuery, name); err != nil {
+		return fmt.Errorf("error executing repeatable migration row delete: %w", err)
+	}
+
+	insert := fmt.Sprintf("INSERT INTO miflo_repeatable (name, checksum) VALUES (%s)", db.placeholders(1, 2))
+	if _, err := db.exec(ctx, tx, insert, name, checksum); err != nil {
+		return fmt.Errorf("error executing repeatable migration row insert: %w", err)
+	}
+
+	return nil
+}
+
+func (db *sqlDatabase) GetAppliedSeeds(ctx context.Context) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM miflo_seeds")
+	if err != nil {
+		return nil, fmt.Errorf("error querying for applied seeds: %w", err)
+	}
+
+	defer rows.Close()
+
+	return helpers.GetAppliedMigrationNames(rows)
+}
+
+func (db *sqlDatabase) RecordSeed(ctx context.Context, tx *sql.Tx, name string) error {
+	deleteQuery := fmt.Sprintf("DELETE FROM miflo_seeds WHERE name = %s", db.dialect.Placeholder(1))
+	if _, err := db.exec(ctx, tx, deleteQuery, name); err != nil {
+		return fmt.Errorf("error executing seed row delete: %w", err)
+	}
+
+	insert := fmt.Sprintf("INSERT INTO miflo_seeds (name) VALUES (%s)", db.dialect.Placeholder(1))
+	if _, err := db.exec(ctx, tx, insert, name); err != nil {
+		return fmt.Errorf("error executing seed row insert: %w", err)
+	}
+
+	return nil
+}
+
+func (db *sqlDatabase) Introspect(ctx context.Context) (*Schema, error) {
+	return db.dialect.Introspect(ctx, db.DB)
+}
+
+func (db *sqlDatabase) Lock(ctx context.Context) (func() error, error) {
+	return db.dialect.Lock(ctx, db.DB)
+}
+
+func (db *sqlDatabase) GetNextBatchNumber(ctx context.Context) (int, error) {
+	var maxBatchNum int
+	err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(batch), 0) + 1 FROM miflo_migrations").Scan(&maxBatchNum)
+	if err != nil {
+		return 0, err
+	}
+	return maxBatchNum, nil
+}
+
+func (db *sqlDatabase) GetLastBatchNumber(ctx context.Context) (int, error) {
+	var lastBatchNum int
+	err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(batch), 0) FROM miflo_migrations").Scan(&lastBatchNum)
+	if err != nil {
+		return 0, err
+	}
+
+	return lastBatchNum, nil
+
+}
+
+// migrationsTableColumns are the columns added to miflo_migrations after its
+// first release. They are added to tables created by older versions.
+var migrationsTableColumns = []struct {
+	name       string
+	definition string
+}{
+	{"checksum", "VARCHAR(64)"},
+	{"dirty", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"duration_ms", "INTEGER"},
+	{"direction", "VARCHAR(4)"},
+}
+
+// TablesSQL returns the statements that create the tables miflo keeps its
+// state in, as NewDatabase runs them for dialect.
+func TablesSQL(dialect Dialect) []string {
+	return []string{dialect.MigrationsTableSQL(), repeatableTableSQL, seedsTableSQL}
+}
+
+func (db *sqlDatabase) ensureMigrationsTable() error {
+	if _, err := db.Exec(db.dialect.MigrationsTableSQL()); err != nil {
+		return err
+	}
+
+	for _, column := range migrationsTableColumns {
+		if err := ensureColumn(db.DB, "miflo_migrations", column.name, column.definition); err != nil {
+			return err
+		}
+	}
+
+	if _, err := db.Exec(repeatableTableSQL); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(seedsTableSQL)
+	return err
+}
+
+// ensureColumn adds column to a table created by an older version of miflo.
+func ensureColumn(db *sql.DB, table string, column string, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("SELECT %s FROM %s WHERE 1 = 0", column, table))
+	if err == nil {
+		return rows.Close()
+	}
+
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("error adding column %s to %s: %w", column, table, err)
+	}
+
+	return nil
+}
@@ -3,179 +3,314 @@ package database
 import (
 	"context"
 	"database/sql"
-	"errors"
 	"fmt"
-	"os"
-	"path"
-
-	"github.com/gavsidhu/miflo/internal/helpers"
+	"strings"
+	"time"
 )
 
-type PostgresDB struct {
-	*sql.DB
-}
-
-func (db *PostgresDB) ApplyMigration(ctx context.Context, tx *sql.Tx, migrationName string, cwd string) error {
-	upFilePath := path.Join(cwd, "migrations", migrationName, "up.sql")
-	sqlBytes, err := os.ReadFile(upFilePath)
-	if err != nil {
-		return fmt.Errorf("error reading SQL file %s: %w", upFilePath, err)
-	}
+type postgresDialect struct{}
 
-	if _, err = tx.ExecContext(ctx, string(sqlBytes)); err != nil {
-		return fmt.Errorf("error executing migration file %s: %w", upFilePath, err)
-	}
-
-	return nil
+func init() {
+	registerDialect(postgresDialect{}, "postgresql", "postgres")
 }
 
-func (db *PostgresDB) RecordMigration(ctx context.Context, tx *sql.Tx, migrationName string, batchNum int) error {
-	if _, err := tx.ExecContext(ctx, "INSERT INTO miflo_migrations (name, batch, applied) VALUES ($1, $2, $3)", migrationName, batchNum, true); err != nil {
-		return fmt.Errorf("error executing migration row insert: %w", err)
-	}
-
-	return nil
+func (postgresDialect) Name() string {
+	return "postgres"
 }
 
-func (db *PostgresDB) RevertMigration(ctx context.Context, tx *sql.Tx, migrationName string, cwd string) error {
-	downFilePath := path.Join(cwd, "migrations", migrationName, "down.sql")
-	sqlBytes, err := os.ReadFile(downFilePath)
-	if err != nil {
-		return fmt.Errorf("error reading SQL file %s: %w", downFilePath, err)
-	}
-
-	_, err = tx.ExecContext(ctx, string(sqlBytes))
-	if err != nil {
-		return fmt.Errorf("error executing migration down file: %s %w", downFilePath, err)
-	}
+func (postgresDialect) DriverName() string {
+	return "postgres"
+}
 
-	return nil
+func (postgresDialect) DataSourceName(databaseURL string) string {
+	return databaseURL
 }
 
-func (db *PostgresDB) DeleteMigration(ctx context.Context, tx *sql.Tx, batchNum int) error {
-	_, err := tx.ExecContext(ctx, "DELETE FROM miflo_migrations where batch = $1", batchNum)
-	if err != nil {
-		return fmt.Errorf("error exectuting migration row delete: %w", err)
-	}
+func (postgresDialect) Placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
 
-	return nil
+func (postgresDialect) MigrationsTableSQL() string {
+	return `
+    CREATE TABLE IF NOT EXISTS miflo_migrations (
+        id SERIAL PRIMARY KEY,
+        name VARCHAR(255) UNIQUE NOT NULL,
+        batch INTEGER NOT NULL,
+        applied BOOLEAN NOT NULL,
+        checksum VARCHAR(64),
+        dirty BOOLEAN NOT NULL DEFAULT FALSE,
+        duration_ms INTEGER,
+        direction VARCHAR(4),
+        applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );`
 }
 
-func (db *PostgresDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
-	return db.DB.BeginTx(ctx, opts)
+func (postgresDialect) Introspect(ctx context.Context, db Querier) (*Schema, error) {
+	return introspectPostgres(ctx, db)
 }
 
-func (db *PostgresDB) Close() error {
-	return db.DB.Close()
+func (postgresDialect) TransactionalDDL() bool {
+	return true
 }
 
-func (db *PostgresDB) GetUnappliedMigrations(cwd string) ([]string, error) {
-	dirMigrations, err := helpers.GetDirMigrations(cwd)
-	if err != nil {
-		return nil, err
+// SetTimeouts sets statement_timeout and lock_timeout for the rest of the
+// transaction, so PostgreSQL itself cancels a statement that runs or waits
+// for a lock too long.
+func (postgresDialect) SetTimeouts(ctx context.Context, tx *sql.Tx, timeouts Timeouts) (context.Context, context.CancelFunc, error) {
+	settings := []struct {
+		name    string
+		timeout time.Duration
+	}{
+		{"statement_timeout", timeouts.Statement},
+		{"lock_timeout", timeouts.Lock},
 	}
 
-	appliedMigrationsRows, err := db.GetAppliedMigrations()
-	if err != nil {
-		fmt.Println("error getting applied migrations:", err)
-		return nil, fmt.Errorf("error getting applied migrations: %w", err)
+	for _, setting := range settings {
+		value := "DEFAULT"
+		if setting.timeout > 0 {
+			value = fmt.Sprintf("'%dms'", milliseconds(setting.timeout))
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL %s = %s", setting.name, value)); err != nil {
+			return nil, nil, fmt.Errorf("error setting %s: %w", setting.name, err)
+		}
 	}
 
-	defer appliedMigrationsRows.Close()
+	return ctx, func() {}, nil
+}
 
-	appliedMigrations, err := helpers.GetAppliedMigrationNames(appliedMigrationsRows)
+// Lock takes a session level advisory lock keyed on the current schema, so
+// migrations of different schemas in the same database do not block each
+// other. The lock is held by a dedicated connection and released by the
+// server if the process dies.
+func (postgresDialect) Lock(ctx context.Context, db *sql.DB) (func() error, error) {
+	conn, err := db.Conn(ctx)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error acquiring migration lock: %w", err)
+	}
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext('miflo:' || current_schema()))"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error acquiring migration lock: %w", err)
 	}
 
-	var pendingMigrations []string
-	for _, migration := range dirMigrations {
-		if !helpers.Contains(appliedMigrations, migration) {
-			pendingMigrations = append(pendingMigrations, migration)
+	release := func() error {
+		defer conn.Close()
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext('miflo:' || current_schema()))"); err != nil {
+			return fmt.Errorf("error releasing migration lock: %w", err)
 		}
+		return nil
 	}
 
-	return pendingMigrations, nil
+	return release, nil
 }
 
-func (db *PostgresDB) GetAppliedMigrations() (*sql.Rows, error) {
-	rows, err := db.Query("SELECT name FROM miflo_migrations WHERE applied = TRUE")
-	if err != nil {
-		fmt.Println("Error getting applied migrations:", err)
-		return nil, fmt.Errorf("error querying for applied migrations: %w", err)
+func introspectPostgres(ctx context.Context, db Querier) (*Schema, error) {
+	schema := &Schema{Dialect: "postgres"}
+
+	var schemaName string
+	if err := queryRow(ctx, db, "SELECT current_schema()", &schemaName); err != nil {
+		return nil, fmt.Errorf("error querying current schema: %w", err)
 	}
 
-	return rows, nil
-}
+	// Definitions generated by PostgreSQL qualify objects with their schema,
+	// which is removed so schemas can be compared and recreated elsewhere.
+	unqualify := func(definition string) string {
+		definition = strings.ReplaceAll(definition, `"`+schemaName+`".`, "")
+		return strings.ReplaceAll(definition, schemaName+".", "")
+	}
 
-func (db *PostgresDB) GetMigrationsToRevert(batch int) ([]string, error) {
-	appliedMigrationsByBatch, err := db.GetAppliedMigrationsByBatch(batch)
+	err := queryEach(ctx, db, `
+    SELECT t.typname, string_agg(quote_literal(e.enumlabel), ', ' ORDER BY e.enumsortorder)
+    FROM pg_type t
+    JOIN pg_enum e ON e.enumtypid = t.oid
+    JOIN pg_namespace n ON n.oid = t.typnamespace
+    WHERE n.nspname = current_schema()
+    GROUP BY t.oid, t.typname
+    ORDER BY t.oid`, func(rows *sql.Rows) error {
+		var name, labels string
+		if err := rows.Scan(&name, &labels); err != nil {
+			return err
+		}
+		schema.Types = append(schema.Types, SchemaObject{name, fmt.Sprintf("CREATE TYPE %s AS ENUM (%s)", name, labels)})
+		return nil
+	})
 	if err != nil {
-		return nil, err
-	}
-	if appliedMigrationsByBatch == nil {
-		return nil, errors.New("no applied migrations found")
+		return nil, fmt.Errorf("error querying types: %w", err)
 	}
-	defer appliedMigrationsByBatch.Close()
 
-	var migrationsToRevert []string
-	for appliedMigrationsByBatch.Next() {
+	err = queryEach(ctx, db, `
+    SELECT c.relname FROM pg_class c
+    JOIN pg_namespace n ON n.oid = c.relnamespace
+    WHERE n.nspname = current_schema() AND c.relkind = 'S'
+      AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.objid = c.oid AND d.deptype = 'i')
+    ORDER BY c.oid`, func(rows *sql.Rows) error {
 		var name string
-		if err := appliedMigrationsByBatch.Scan(&name); err != nil {
-			fmt.Printf("Error scanning migration name: %v\n", err)
-			return nil, err
+		if err := rows.Scan(&name); err != nil {
+			return err
 		}
-		migrationsToRevert = append(migrationsToRevert, name)
+		if !isMifloTable(name) {
+			schema.Sequences = append(schema.Sequences, SchemaObject{name, "CREATE SEQUENCE " + name})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error querying sequences: %w", err)
 	}
 
-	if err := appliedMigrationsByBatch.Err(); err != nil {
-		fmt.Printf("error during iteration over applied migrations: %v\n", err)
-		return nil, err
+	var tableOIDs []int64
+	err = queryEach(ctx, db, `
+    SELECT c.oid, c.relname FROM pg_class c
+    JOIN pg_namespace n ON n.oid = c.relnamespace
+    WHERE n.nspname = current_schema() AND c.relkind IN ('r', 'p')
+    ORDER BY c.oid`, func(rows *sql.Rows) error {
+		var oid int64
+		var name string
+		if err := rows.Scan(&oid, &name); err != nil {
+			return err
+		}
+		if !isMifloTable(name) {
+			tableOIDs = append(tableOIDs, oid)
+			schema.Tables = append(schema.Tables, Table{Name: name})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error querying tables: %w", err)
 	}
 
-	return migrationsToRevert, nil
-}
+	for i, oid := range tableOIDs {
+		table := &schema.Tables[i]
+
+		err = queryEach(ctx, db, `
+        SELECT a.attname, format_type(a.atttypid, a.atttypmod), a.attnotnull,
+               COALESCE(pg_get_expr(d.adbin, d.adrelid), ''), a.attidentity::text, a.attgenerated::text
+        FROM pg_attribute a
+        LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+        WHERE a.attrelid = $1 AND a.attnum > 0 AND NOT a.attisdropped
+        ORDER BY a.attnum`, func(rows *sql.Rows) error {
+			var column Column
+			var identity, generated string
+			if err := rows.Scan(&column.Name, &column.Type, &column.NotNull, &column.Default, &identity, &generated); err != nil {
+				return err
+			}
+			column.Default = unqualify(column.Default)
+			switch {
+			case identity == "a":
+				column.Extra = "GENERATED ALWAYS AS IDENTITY"
+			case identity == "d":
+				column.Extra = "GENERATED BY DEFAULT AS IDENTITY"
+			case generated == "s":
+				column.Extra = fmt.Sprintf("GENERATED ALWAYS AS (%s) STORED", column.Default)
+			}
+			table.Columns = append(table.Columns, column)
+			return nil
+		}, oid)
+		if err != nil {
+			return nil, fmt.Errorf("error querying columns of %s: %w", table.Name, err)
+		}
 
-func (db *PostgresDB) GetAppliedMigrationsByBatch(batch int) (*sql.Rows, error) {
-	query := "SELECT name FROM miflo_migrations WHERE applied = true AND batch = $1"
-	rows, err := db.Query(query, batch)
-	if err != nil {
-		fmt.Printf("error getting applied migrations for batch %d: %v\n", batch, err)
-		return nil, err
+		err = queryEach(ctx, db, `
+        SELECT conname, contype::text, pg_get_constraintdef(oid)
+        FROM pg_constraint
+        WHERE conrelid = $1 AND contype IN ('p', 'u', 'c', 'f', 'x')
+        ORDER BY contype = 'f', oid`, func(rows *sql.Rows) error {
+			var constraint Constraint
+			var constraintType string
+			if err := rows.Scan(&constraint.Name, &constraintType, &constraint.Definition); err != nil {
+				return err
+			}
+			constraint.Definition = unqualify(constraint.Definition)
+			constraint.ForeignKey = constraintType == "f"
+			table.Constraints = append(table.Constraints, constraint)
+			return nil
+		}, oid)
+		if err != nil {
+			return nil, fmt.Errorf("error querying constraints of %s: %w", table.Name, err)
+		}
 	}
 
-	return rows, nil
-}
-
-func (db *PostgresDB) GetNextBatchNumber() (int, error) {
-	var maxBatchNum int
-	err := db.QueryRow("SELECT COALESCE(MAX(batch), 0) + 1 FROM miflo_migrations").Scan(&maxBatchNum)
+	err = queryEach(ctx, db, `
+    SELECT c.relname, c.relkind::text, pg_get_viewdef(c.oid)
+    FROM pg_class c
+    JOIN pg_namespace n ON n.oid = c.relnamespace
+    WHERE n.nspname = current_schema() AND c.relkind IN ('v', 'm')
+    ORDER BY c.oid`, func(rows *sql.Rows) error {
+		var name, kind, definition string
+		if err := rows.Scan(&name, &kind, &definition); err != nil {
+			return err
+		}
+		createView := "CREATE VIEW"
+		if kind == "m" {
+			createView = "CREATE MATERIALIZED VIEW"
+		}
+		definition = strings.TrimSuffix(strings.TrimSpace(unqualify(definition)), ";")
+		schema.Views = append(schema.Views, SchemaObject{name, fmt.Sprintf("%s %s AS\n%s", createView, name, definition)})
+		return nil
+	})
 	if err != nil {
-		return 0, err
+		return nil, fmt.Errorf("error querying views: %w", err)
 	}
-	return maxBatchNum, nil
-}
 
-func (db *PostgresDB) GetLastBatchNumber() (int, error) {
-	var lastBatchNum int
-	err := db.QueryRow("SELECT COALESCE(MAX(batch), 0) FROM miflo_migrations").Scan(&lastBatchNum)
+	err = queryEach(ctx, db, `
+    SELECT ic.relname, tc.relname, pg_get_indexdef(ix.indexrelid)
+    FROM pg_index ix
+    JOIN pg_class ic ON ic.oid = ix.indexrelid
+    JOIN pg_class tc ON tc.oid = ix.indrelid
+    JOIN pg_namespace n ON n.oid = tc.relnamespace
+    WHERE n.nspname = current_schema()
+      AND NOT EXISTS (SELECT 1 FROM pg_constraint c WHERE c.conindid = ix.indexrelid)
+    ORDER BY ic.oid`, func(rows *sql.Rows) error {
+		var index Index
+		if err := rows.Scan(&index.Name, &index.Table, &index.Definition); err != nil {
+			return err
+		}
+		if !isMifloTable(index.Table) {
+			index.Definition = unqualify(index.Definition)
+			schema.Indexes = append(schema.Indexes, index)
+		}
+		return nil
+	})
 	if err != nil {
-		return 0, err
+		return nil, fmt.Errorf("error querying indexes: %w", err)
 	}
 
-	return lastBatchNum, nil
+	err = queryEach(ctx, db, `
+    SELECT p.oid::regprocedure::text, pg_get_functiondef(p.oid)
+    FROM pg_proc p
+    JOIN pg_namespace n ON n.oid = p.pronamespace
+    WHERE n.nspname = current_schema() AND p.prokind IN ('f', 'p')
+      AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.objid = p.oid AND d.deptype = 'e')
+    ORDER BY p.oid`, func(rows *sql.Rows) error {
+		var name, definition string
+		if err := rows.Scan(&name, &definition); err != nil {
+			return err
+		}
+		schema.Functions = append(schema.Functions, SchemaObject{unqualify(name), strings.TrimSpace(unqualify(definition))})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error querying functions: %w", err)
+	}
 
-}
+	err = queryEach(ctx, db, `
+    SELECT t.tgname, c.relname, pg_get_triggerdef(t.oid)
+    FROM pg_trigger t
+    JOIN pg_class c ON c.oid = t.tgrelid
+    JOIN pg_namespace n ON n.oid = c.relnamespace
+    WHERE n.nspname = current_schema() AND NOT t.tgisinternal
+    ORDER BY t.oid`, func(rows *sql.Rows) error {
+		var name, table, definition string
+		if err := rows.Scan(&name, &table, &definition); err != nil {
+			return err
+		}
+		if !isMifloTable(table) {
+			schema.Triggers = append(schema.Triggers, SchemaObject{name, unqualify(definition)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error querying triggers: %w", err)
+	}
 
-func (db *PostgresDB) ensureMigrationsTable() error {
-	createTableSQL := `
-    CREATE TABLE IF NOT EXISTS miflo_migrations (
-        id SERIAL PRIMARY KEY,
-        name VARCHAR(255) UNIQUE NOT NULL,
-        batch INTEGER NOT NULL,
-        applied BOOLEAN NOT NULL,
-        applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
-    );`
-	_, err := db.Exec(createTableSQL)
-	return err
+	return schema, nil
 }
@@ -0,0 +1,249 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Schema describes the objects of a database, excluding miflo's own tables.
+// Objects are listed in creation order.
+type Schema struct {
+	Dialect   string
+	Types     []SchemaObject
+	Sequences []SchemaObject
+	Tables    []Table
+	Views     []SchemaObject
+	Indexes   []Index
+	Functions []SchemaObject
+	Triggers  []SchemaObject
+}
+
+type SchemaObject struct {
+	Name       string
+	Definition string
+}
+
+type Table struct {
+	Name        string
+	Columns     []Column
+	Constraints []Constraint
+	// Definition is the CREATE TABLE statement when the database stores it,
+	// as SQLite does. Otherwise the table is rebuilt from its columns and
+	// constraints.
+	Definition string
+}
+
+type Column struct {
+	Name    string
+	Type    string
+	NotNull bool
+	Default string
+	// Extra holds identity or generated column clauses.
+	Extra string
+}
+
+type Constraint struct {
+	Name       string
+	Definition string
+	ForeignKey bool
+}
+
+type Index struct {
+	Name       string
+	Table      string
+	Definition string
+}
+
+// CreateStatements returns the statements that recreate the schema in an
+// empty database.
+func (s *Schema) CreateStatements() []string {
+	var statements []string
+
+	for _, t := range s.Types {
+		statements = append(statements, t.Definition)
+	}
+
+	for _, sequence := range s.Sequences {
+		statements = append(statements, sequence.Definition)
+	}
+
+	var constraints, foreignKeys []string
+	for _, table := range s.Tables {
+		if table.Definition != "" {
+			statements = append(statements, table.Definition)
+			continue
+		}
+
+		var columns []string
+		for _, column := range table.Columns {
+			definition := column.Name + " " + column.Type
+			if column.Extra != "" {
+				definition += " " + column.Extra
+			} else if column.Default != "" {
+				definition += " DEFAULT " + column.Default
+			}
+			if column.NotNull {
+				definition += " NOT NULL"
+			}
+			columns = append(columns, "    "+definition)
+		}
+		statements = append(statements, fmt.Sprintf("CREATE TABLE %s (\n%s\n)", table.Name, strings.Join(columns, ",\n")))
+
+		for _, constraint := range table.Constraints {
+			statement := fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s", table.Name, constraint.Name, constraint.Definition)
+			if constraint.ForeignKey {
+				foreignKeys = append(foreignKeys, statement)
+			} else {
+				constraints = append(constraints, statement)
+			}
+		}
+	}
+
+	statements = append(statements, constraints...)
+	statements = append(statements, foreignKeys...)
+
+	for _, function := range s.Functions {
+		statements = append(statements, function.Definition)
+	}
+
+	for _, view := range s.Views {
+		statements = append(statements, view.Definition)
+	}
+
+	for _, index := range s.Indexes {
+		statements = append(statements, index.Definition)
+	}
+
+	for _, trigger := range s.Triggers {
+		statements = append(statements, trigger.Definition)
+	}
+
+	return statements
+}
+
+func isMifloTable(name string) bool {
+	return strings.HasPrefix(name, "miflo_")
+}
+
+func queryRow(ctx context.Context, db Querier, query string, dest ...any) error {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+
+	return rows.Scan(dest...)
+}
+
+func queryEach(ctx context.Context, db Querier, query string, scan func(rows *sql.Rows) error, args ...any) error {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// NewScratchDatabase returns an empty database of the same dialect as
+// databaseURL that migrations can be replayed into. SQLite and libSQL use an
+// in-memory SQLite database. PostgreSQL uses a temporary schema in the
+// database at databaseURL, set as the search path so unqualified objects
+// are created in it. Migrations that name another schema, or create
+// extensions or roles, change more than the temporary schema. The returned
+// function removes the scratch database.
+func NewScratchDatabase(databaseURL string) (Database, func() error, error) {
+	dialect, err := DialectName(databaseURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if dialect != "postgres" {
+		db, err := sql.Open("sqlite3", ":memory:")
+		if err != nil {
+			return nil, nil, fmt.Errorf("error opening scratch SQLite database: %w", err)
+		}
+
+		// Every connection to :memory: opens a separate database.
+		db.SetMaxOpenConns(1)
+
+		scratch, err := newSQLDatabase(db, sqliteDialect{})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return scratch, db.Close, nil
+	}
+
+	admin, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening PostgreSQL database: %w", err)
+	}
+
+	schemaName := fmt.Sprintf("miflo_scratch_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schemaName); err != nil {
+		admin.Close()
+		return nil, nil, fmt.Errorf("error creating scratch schema: %w", err)
+	}
+
+	dropSchema := func() error {
+		defer admin.Close()
+		if _, err := admin.Exec("DROP SCHEMA IF EXISTS " + schemaName + " CASCADE"); err != nil {
+			return fmt.Errorf("error dropping scratch schema %s: %w", schemaName, err)
+		}
+		return nil
+	}
+
+	scratchURL, err := WithSearchPath(databaseURL, schemaName)
+	if err != nil {
+		dropSchema()
+		return nil, nil, err
+	}
+
+	scratch, err := NewDatabase(scratchURL)
+	if err != nil {
+		dropSchema()
+		return nil, nil, err
+	}
+
+	cleanup := func() error {
+		scratch.Close()
+		return dropSchema()
+	}
+
+	return scratch, cleanup, nil
+}
+
+// WithSearchPath returns a PostgreSQL connection URL whose connections use
+// schema as their search_path.
+func WithSearchPath(databaseURL string, schema string) (string, error) {
+	u, err := url.Parse(databaseURL)
+	if err != nil {
+		return "", fmt.Errorf("error parsing database URL: %w", err)
+	}
+
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
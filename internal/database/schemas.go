@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errSchemasUnsupported = errors.New("schemas are only supported on PostgreSQL")
+
+// ListSchemas returns the PostgreSQL schemas whose names match the LIKE
+// pattern, or the schemas that contain a miflo_migrations table when pattern
+// is empty. System schemas are never returned.
+func ListSchemas(ctx context.Context, db Database, pattern string) ([]string, error) {
+	if db.Dialect().Name() != "postgres" {
+		return nil, errSchemasUnsupported
+	}
+
+	query := `
+    SELECT nspname FROM pg_namespace
+    WHERE nspname LIKE $1 AND nspname NOT LIKE 'pg\_%' AND nspname <> 'information_schema'
+    ORDER BY nspname`
+	args := []any{pattern}
+	if pattern == "" {
+		query = `
+    SELECT table_schema FROM information_schema.tables
+    WHERE table_name = 'miflo_migrations'
+    ORDER BY table_schema`
+		args = nil
+	}
+
+	var schemas []string
+	err := queryEach(ctx, db, query, func(rows *sql.Rows) error {
+		var schema string
+		if err := rows.Scan(&schema); err != nil {
+			return err
+		}
+		schemas = append(schemas, schema)
+		return nil
+	}, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing schemas: %w", err)
+	}
+
+	return schemas, nil
+}
+
+// SchemaAppliedMigrations returns the migrations applied to a PostgreSQL
+// schema without connecting to it. It returns no migrations when the schema
+// has no miflo_migrations table.
+func SchemaAppliedMigrations(ctx context.Context, db Database, schema string) ([]string, error) {
+	if db.Dialect().Name() != "postgres" {
+		return nil, errSchemasUnsupported
+	}
+
+	exists, err := schemaHasColumn(ctx, db, schema, "name")
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("SELECT name FROM %s.miflo_migrations WHERE applied = TRUE", quoteIdentifier(schema))
+	migrations, err := schemaMigrationNames(ctx, db, query)
+	if err != nil {
+		return nil, fmt.Errorf("error querying applied migrations in %s: %w", schema, err)
+	}
+
+	return migrations, nil
+}
+
+// SchemaDirtyMigrations returns the dirty migrations of a PostgreSQL schema
+// without connecting to it. It returns no migrations when the schema has no
+// miflo_migrations table, or one created before migrations could be dirty.
+func SchemaDirtyMigrations(ctx context.Context, db Database, schema string) ([]string, error) {
+	if db.Dialect().Name() != "postgres" {
+		return nil, errSchemasUnsupported
+	}
+
+	exists, err := schemaHasColumn(ctx, db, schema, "dirty")
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("SELECT name FROM %s.miflo_migrations WHERE dirty = TRUE ORDER BY name", quoteIdentifier(schema))
+	migrations, err := schemaMigrationNames(ctx, db, query)
+	if err != nil {
+		return nil, fmt.Errorf("error querying dirty migrations in %s: %w", schema, err)
+	}
+
+	return migrations, nil
+}
+
+// schemaHasColumn reports whether the miflo_migrations table of schema
+// exists and has the column.
+func schemaHasColumn(ctx context.Context, db Database, schema string, column string) (bool, error) {
+	var exists bool
+	err := queryEach(ctx, db, `
+    SELECT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = $1 AND table_name = 'miflo_migrations' AND column_name = $2
+    )`, func(rows *sql.Rows) error {
+		return rows.Scan(&exists)
+	}, schema, column)
+	if err != nil {
+		return false, fmt.Errorf("error checking for migrations table in %s: %w", schema, err)
+	}
+
+	return exists, nil
+}
+
+func schemaMigrationNames(ctx context.Context, db Database, query string) ([]string, error) {
+	var migrations []string
+	err := queryEach(ctx, db, query, func(rows *sql.Rows) error {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		migrations = append(migrations, name)
+		return nil
+	})
+	return migrations, err
+}
+
+// quoteIdentifier quotes a PostgreSQL identifier such as a schema name.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -6,178 +6,334 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"path"
-
-	"github.com/gavsidhu/miflo/internal/helpers"
+	"strings"
+	"syscall"
+	"time"
 )
 
-type SQLiteDB struct {
-	*sql.DB
-}
+type sqliteDialect struct{}
 
-func (db *SQLiteDB) ApplyMigration(ctx context.Context, tx *sql.Tx, migrationName string, cwd string) error {
-	upFilePath := path.Join(cwd, "migrations", migrationName, "up.sql")
-	sqlBytes, err := os.ReadFile(upFilePath)
-	if err != nil {
-		return fmt.Errorf("error reading SQL file %s: %w", upFilePath, err)
-	}
+func init() {
+	registerDialect(sqliteDialect{}, "sqlite")
+}
 
-	if _, err = tx.ExecContext(ctx, string(sqlBytes)); err != nil {
-		return fmt.Errorf("error executing migration file %s: %w", upFilePath, err)
-	}
+func (sqliteDialect) Name() string {
+	return "sqlite"
+}
 
-	return nil
+func (sqliteDialect) DriverName() string {
+	return "sqlite3"
 }
 
-func (db *SQLiteDB) RecordMigration(ctx context.Context, tx *sql.Tx, migrationName string, batchNum int) error {
-	if _, err := tx.ExecContext(ctx, "INSERT INTO miflo_migrations (name, batch, applied) VALUES (?, ?, ?)", migrationName, batchNum, true); err != nil {
-		return fmt.Errorf("error executing migration row insert: %w", err)
-	}
+func (sqliteDialect) DataSourceName(databaseURL string) string {
+	return strings.TrimPrefix(databaseURL, "sqlite:")
+}
 
-	return nil
+func (sqliteDialect) Placeholder(n int) string {
+	return "?"
 }
 
-func (db *SQLiteDB) RevertMigration(ctx context.Context, tx *sql.Tx, migrationName string, cwd string) error {
-	downFilePath := path.Join(cwd, "migrations", migrationName, "down.sql")
-	sqlBytes, err := os.ReadFile(downFilePath)
-	if err != nil {
-		return fmt.Errorf("error reading SQL file %s: %w", downFilePath, err)
-	}
+func (sqliteDialect) MigrationsTableSQL() string {
+	return `
+    CREATE TABLE IF NOT EXISTS miflo_migrations (
+        id INTEGER PRIMARY KEY,
+        name TEXT UNIQUE NOT NULL,
+        batch INTEGER NOT NULL,
+        applied BOOLEAN NOT NULL,
+        checksum VARCHAR(64),
+        dirty BOOLEAN NOT NULL DEFAULT FALSE,
+        duration_ms INTEGER,
+        direction VARCHAR(4),
+        applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );`
+}
 
-	_, err = tx.ExecContext(ctx, string(sqlBytes))
-	if err != nil {
-		return fmt.Errorf("error exectuing migration down file: %s %w", downFilePath, err)
-	}
+func (sqliteDialect) Introspect(ctx context.Context, db Querier) (*Schema, error) {
+	return introspectSQLite(ctx, db)
+}
 
-	return nil
+func (sqliteDialect) TransactionalDDL() bool {
+	return true
 }
 
-func (db *SQLiteDB) DeleteMigration(ctx context.Context, tx *sql.Tx, batchNum int) error {
-	_, err := tx.ExecContext(ctx, "DELETE FROM miflo_migrations where batch = ?", batchNum)
-	if err != nil {
-		return fmt.Errorf("error executing migration row delete: %w", err)
+// sqliteBusyTimeout is the busy timeout go-sqlite3 opens connections with.
+const sqliteBusyTimeout = 5 * time.Second
+
+// SetTimeouts uses the busy timeout of the connection as the lock timeout,
+// since SQLite only waits for locks on the whole database, and a context
+// deadline, which interrupts the running statement, as the statement timeout.
+func (sqliteDialect) SetTimeouts(ctx context.Context, tx *sql.Tx, timeouts Timeouts) (context.Context, context.CancelFunc, error) {
+	busyTimeout := sqliteBusyTimeout
+	if timeouts.Lock > 0 {
+		busyTimeout = timeouts.Lock
 	}
 
-	return nil
-}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA busy_timeout = %d", milliseconds(busyTimeout))); err != nil {
+		return nil, nil, fmt.Errorf("error setting busy_timeout: %w", err)
+	}
 
-func (db *SQLiteDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
-	return db.DB.BeginTx(ctx, opts)
+	ctx, cancel := withDeadline(ctx, timeouts.Statement)
+	return ctx, cancel, nil
 }
 
-func (db *SQLiteDB) Close() error {
-	return db.DB.Close()
+// sqliteLockColumns are the columns added to miflo_lock after its first
+// release.
+var sqliteLockColumns = []struct {
+	name       string
+	definition string
+}{
+	{"host", "TEXT"},
+	{"pid", "INTEGER"},
 }
 
-func (db *SQLiteDB) GetUnappliedMigrations(cwd string) ([]string, error) {
-	dirMigrations, err := helpers.GetDirMigrations(cwd)
+// Lock inserts the single row of the miflo_lock table, recording the host
+// and pid of the process holding it. SQLite has no advisory locks, so a row
+// left behind by a process on this host that is no longer running is taken
+// over, while one left by another host has to be removed by hand.
+func (sqliteDialect) Lock(ctx context.Context, db *sql.DB) (func() error, error) {
+	_, err := db.ExecContext(ctx, `
+    CREATE TABLE IF NOT EXISTS miflo_lock (
+        id INTEGER PRIMARY KEY CHECK (id = 1),
+        host TEXT,
+        pid INTEGER,
+        locked_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );`)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error setting up lock table: %w", err)
 	}
 
-	appliedMigrationsRows, err := db.GetAppliedMigrations()
-	if err != nil {
-		fmt.Println("error getting applied migrations:", err)
-		return nil, fmt.Errorf("error getting applied migrations: %w", err)
+	for _, column := range sqliteLockColumns {
+		if err := ensureColumn(db, "miflo_lock", column.name, column.definition); err != nil {
+			return nil, fmt.Errorf("error setting up lock table: %w", err)
+		}
 	}
 
-	defer appliedMigrationsRows.Close()
+	host, _ := os.Hostname()
+	pid := os.Getpid()
 
-	appliedMigrations, err := helpers.GetAppliedMigrationNames(appliedMigrationsRows)
-	if err != nil {
-		return nil, err
+	if _, err := db.ExecContext(ctx, "INSERT INTO miflo_lock (id, host, pid) VALUES (1, ?, ?)", host, pid); err != nil {
+		var lockedAt string
+		var lockHost sql.NullString
+		var lockPid sql.NullInt64
+		if scanErr := db.QueryRowContext(ctx, "SELECT locked_at, host, pid FROM miflo_lock WHERE id = 1").Scan(&lockedAt, &lockHost, &lockPid); scanErr != nil {
+			return nil, fmt.Errorf("error acquiring migration lock: %w", err)
+		}
+
+		if !lockHost.Valid || lockHost.String != host || !lockPid.Valid || processRunning(int(lockPid.Int64)) {
+			holder := "another miflo process"
+			if lockHost.Valid && lockPid.Valid {
+				holder = fmt.Sprintf("miflo process %d on %s", lockPid.Int64, lockHost.String)
+			}
+			return nil, fmt.Errorf("database is locked by %s since %s, if it is no longer running remove the lock with: DELETE FROM miflo_lock", holder, lockedAt)
+		}
+
+		// The update only matches the stale row, so of two processes taking
+		// it over at the same time one finds it already replaced.
+		result, err := db.ExecContext(ctx, "UPDATE miflo_lock SET pid = ?, locked_at = CURRENT_TIMESTAMP WHERE id = 1 AND host = ? AND pid = ?", pid, host, lockPid.Int64)
+		if err != nil {
+			return nil, fmt.Errorf("error acquiring migration lock: %w", err)
+		}
+		if taken, err := result.RowsAffected(); err != nil || taken != 1 {
+			return nil, fmt.Errorf("database is locked by another miflo process, which took over the lock of stopped process %d", lockPid.Int64)
+		}
 	}
 
-	var pendingMigrations []string
-	for _, migration := range dirMigrations {
-		if !helpers.Contains(appliedMigrations, migration) {
-			pendingMigrations = append(pendingMigrations, migration)
+	release := func() error {
+		if _, err := db.Exec("DELETE FROM miflo_lock WHERE id = 1 AND host = ? AND pid = ?", host, pid); err != nil {
+			return fmt.Errorf("error releasing migration lock: %w", err)
 		}
+		return nil
 	}
 
-	return pendingMigrations, nil
+	return release, nil
 }
 
-func (db *SQLiteDB) GetAppliedMigrations() (*sql.Rows, error) {
-	rows, err := db.Query("SELECT name FROM miflo_migrations WHERE applied = TRUE")
+// processRunning reports whether a process with pid runs on this host.
+// Signal 0 only checks the process exists, and a platform that cannot send
+// it reports the process as running so its lock is never taken over.
+func processRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
 	if err != nil {
-		fmt.Println("Error getting applied migrations:", err)
-		return nil, fmt.Errorf("error querying for applied migrations: %w", err)
+		return false
 	}
 
-	return rows, nil
+	err = process.Signal(syscall.Signal(0))
+	return !errors.Is(err, os.ErrProcessDone) && !errors.Is(err, syscall.ESRCH)
 }
 
-func (db *SQLiteDB) GetMigrationsToRevert(batch int) ([]string, error) {
-	appliedMigrationsByBatch, err := db.GetAppliedMigrationsByBatch(batch)
+func introspectSQLite(ctx context.Context, db Querier) (*Schema, error) {
+	schema := &Schema{Dialect: "sqlite"}
+
+	rows, err := db.QueryContext(ctx, `
+    SELECT type, name, tbl_name, sql FROM sqlite_master
+    WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%' AND name NOT LIKE 'libsql_%' AND name NOT LIKE '_litestream%'
+    ORDER BY rowid`)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error querying sqlite_master: %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var objectType, name, tableName, definition string
+		if err := rows.Scan(&objectType, &name, &tableName, &definition); err != nil {
+			return nil, err
+		}
+
+		if isMifloTable(tableName) {
+			continue
+		}
+
+		switch objectType {
+		case "table":
+			schema.Tables = append(schema.Tables, Table{Name: name, Definition: definition})
+		case "view":
+			schema.Views = append(schema.Views, SchemaObject{name, definition})
+		case "index":
+			schema.Indexes = append(schema.Indexes, Index{name, tableName, definition})
+		case "trigger":
+			schema.Triggers = append(schema.Triggers, SchemaObject{name, definition})
+		}
 	}
 
-	if appliedMigrationsByBatch == nil {
-		return nil, errors.New("no applied migrations found")
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	defer appliedMigrationsByBatch.Close()
+	for i := range schema.Tables {
+		table := &schema.Tables[i]
 
-	var migrationsToRevert []string
-	for appliedMigrationsByBatch.Next() {
-		var name string
-		if err := appliedMigrationsByBatch.Scan(&name); err != nil {
-			fmt.Printf("Error scanning migration name: %v\n", err)
+		table.Columns, err = sqliteColumns(ctx, db, table.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		table.Constraints, err = sqliteConstraints(ctx, db, table.Name)
+		if err != nil {
 			return nil, err
 		}
-		migrationsToRevert = append(migrationsToRevert, name)
 	}
 
-	if err := appliedMigrationsByBatch.Err(); err != nil {
-		fmt.Printf("Error during iteration over applied migrations: %v\n", err)
-		return nil, err
+	return schema, nil
+}
+
+func sqliteColumns(ctx context.Context, db Querier, table string) ([]Column, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name, type, \"notnull\", dflt_value FROM pragma_table_info(?) ORDER BY cid", table)
+	if err != nil {
+		return nil, fmt.Errorf("error querying columns of %s: %w", table, err)
+	}
+
+	defer rows.Close()
+
+	var columns []Column
+	for rows.Next() {
+		var column Column
+		var defaultValue sql.NullString
+		if err := rows.Scan(&column.Name, &column.Type, &column.NotNull, &defaultValue); err != nil {
+			return nil, err
+		}
+		column.Type = strings.ToUpper(column.Type)
+		column.Default = defaultValue.String
+		columns = append(columns, column)
 	}
 
-	return migrationsToRevert, nil
+	return columns, rows.Err()
 }
 
-func (db *SQLiteDB) GetAppliedMigrationsByBatch(batch int) (*sql.Rows, error) {
-	query := "SELECT name FROM miflo_migrations WHERE applied = 1 AND batch = ?"
-	rows, err := db.Query(query, batch)
+func sqliteConstraints(ctx context.Context, db Querier, table string) ([]Constraint, error) {
+	var constraints []Constraint
+
+	rows, err := db.QueryContext(ctx, "SELECT name, origin FROM pragma_index_list(?) WHERE origin IN ('pk', 'u') ORDER BY name", table)
 	if err != nil {
-		fmt.Printf("Error getting applied migrations for batch %d: %v\n", batch, err)
+		return nil, fmt.Errorf("error querying indexes of %s: %w", table, err)
+	}
+
+	type uniqueIndex struct{ name, origin string }
+	var indexes []uniqueIndex
+	for rows.Next() {
+		var index uniqueIndex
+		if err := rows.Scan(&index.name, &index.origin); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		indexes = append(indexes, index)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
-	return rows, nil
-}
+	for _, index := range indexes {
+		columns, err := sqliteIndexColumns(ctx, db, index.name)
+		if err != nil {
+			return nil, err
+		}
+
+		kind := "UNIQUE"
+		if index.origin == "pk" {
+			kind = "PRIMARY KEY"
+		}
+		constraints = append(constraints, Constraint{
+			Name:       index.name,
+			Definition: fmt.Sprintf("%s (%s)", kind, strings.Join(columns, ", ")),
+		})
+	}
 
-func (db *SQLiteDB) GetNextBatchNumber() (int, error) {
-	var maxBatchNum int
-	err := db.QueryRow("SELECT COALESCE(MAX(batch), 0) + 1 FROM miflo_migrations").Scan(&maxBatchNum)
+	rows, err = db.QueryContext(ctx, "SELECT id, \"table\", \"from\", \"to\" FROM pragma_foreign_key_list(?) ORDER BY id, seq", table)
 	if err != nil {
-		return 0, err
+		return nil, fmt.Errorf("error querying foreign keys of %s: %w", table, err)
 	}
-	return maxBatchNum, nil
+
+	defer rows.Close()
+
+	foreignKeys := make(map[int]*Constraint)
+	var ids []int
+	var from, to = make(map[int][]string), make(map[int][]string)
+	for rows.Next() {
+		var id int
+		var referenced, fromColumn string
+		var toColumn sql.NullString
+		if err := rows.Scan(&id, &referenced, &fromColumn, &toColumn); err != nil {
+			return nil, err
+		}
+		if _, ok := foreignKeys[id]; !ok {
+			ids = append(ids, id)
+			foreignKeys[id] = &Constraint{Name: fmt.Sprintf("%s_fkey_%d", table, id), ForeignKey: true}
+		}
+		from[id] = append(from[id], fromColumn)
+		if toColumn.Valid {
+			to[id] = append(to[id], toColumn.String)
+		}
+		foreignKeys[id].Definition = fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s (%s)", strings.Join(from[id], ", "), referenced, strings.Join(to[id], ", "))
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		constraints = append(constraints, *foreignKeys[id])
+	}
+
+	return constraints, nil
 }
 
-func (db *SQLiteDB) GetLastBatchNumber() (int, error) {
-	var lastBatchNum int
-	err := db.QueryRow("SELECT COALESCE(MAX(batch), 0) FROM miflo_migrations").Scan(&lastBatchNum)
+func sqliteIndexColumns(ctx context.Context, db Querier, index string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM pragma_index_info(?) ORDER BY seqno", index)
 	if err != nil {
-		return 0, err
+		return nil, fmt.Errorf("error querying columns of index %s: %w", index, err)
 	}
 
-	return lastBatchNum, nil
+	defer rows.Close()
 
-}
+	var columns []string
+	for rows.Next() {
+		var column sql.NullString
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column.String)
+	}
 
-func (db *SQLiteDB) ensureMigrationsTable() error {
-	createTableSQL := `
-    CREATE TABLE IF NOT EXISTS miflo_migrations (
-        id INTEGER PRIMARY KEY,
-        name TEXT UNIQUE NOT NULL,
-        batch INTEGER NOT NULL,
-        applied BOOLEAN NOT NULL,
-        applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
-    );`
-	_, err := db.Exec(createTableSQL)
-	return err
+	return columns, rows.Err()
 }
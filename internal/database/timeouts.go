@@ -0,0 +1,48 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// Timeouts limit how long migration statements may run and wait for locks.
+// A zero duration leaves the limit unset.
+type Timeouts struct {
+	Statement time.Duration
+	Lock      time.Duration
+}
+
+// Shortest returns the shortest limit that is set, or zero if none is.
+func (t Timeouts) Shortest() time.Duration {
+	if t.Statement > 0 && (t.Lock <= 0 || t.Statement < t.Lock) {
+		return t.Statement
+	}
+	if t.Lock > 0 {
+		return t.Lock
+	}
+	return 0
+}
+
+// milliseconds returns timeout in whole milliseconds, rounding anything
+// shorter up to one millisecond so it is not mistaken for no timeout.
+func milliseconds(timeout time.Duration) int64 {
+	if ms := timeout.Milliseconds(); ms > 0 {
+		return ms
+	}
+	return 1
+}
+
+// withDeadline returns ctx limited to timeout, or ctx itself if timeout is
+// zero.
+func withDeadline(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// DeadlineContext returns ctx limited to the shortest of the timeouts, for
+// statements that run without a transaction the timeouts can be set in.
+func DeadlineContext(ctx context.Context, timeouts Timeouts) (context.Context, context.CancelFunc) {
+	return withDeadline(ctx, timeouts.Shortest())
+}
@@ -1,7 +1,10 @@
 package helpers
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"path"
@@ -9,10 +12,11 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func ErrAndExit(msg string) {
-	fmt.Fprintln(os.Stderr, msg)
+	fmt.Fprintln(os.Stderr, RedactSecrets(msg))
 	os.Exit(1)
 }
 
@@ -40,6 +44,16 @@ func GetAppliedMigrationNames(rows *sql.Rows) ([]string, error) {
 	return migrations, nil
 }
 
+// RepeatableDir is the directory inside the migrations directory that holds
+// repeatable migrations.
+const RepeatableDir = "repeatable"
+
+// IsHidden reports whether name is a dotfile, such as the .DS_Store files
+// macOS leaves behind, which miflo ignores in the migrations directory.
+func IsHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
 func GetDirMigrations(cwd string) ([]string, error) {
 	entries, err := os.ReadDir(path.Join(cwd, "migrations"))
 	if err != nil {
@@ -48,7 +62,7 @@ func GetDirMigrations(cwd string) ([]string, error) {
 	var migrations []string
 
 	for _, entry := range entries {
-		if entry.IsDir() {
+		if entry.IsDir() && entry.Name() != RepeatableDir && !IsHidden(entry.Name()) {
 			migrations = append(migrations, entry.Name())
 		}
 	}
@@ -67,8 +81,38 @@ func SortDirMigrations(migrations []string, ascending bool) {
 	})
 }
 
+// ParseMigrationDir splits a migration directory name of the form
+// <timestamp>_<name> into its timestamp and name.
+func ParseMigrationDir(dirName string) (int64, string, error) {
+	prefix, name, found := strings.Cut(dirName, "_")
+	if !found {
+		return 0, "", errors.New("missing numeric timestamp prefix")
+	}
+
+	timestamp, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil || timestamp < 0 {
+		return 0, "", errors.New("missing numeric timestamp prefix")
+	}
+
+	return timestamp, name, nil
+}
+
 func IsValidMigrationName(migrationName string) bool {
 	validNamePattern := regexp.MustCompile(`^[A-Za-z_]+$`)
 
 	return validNamePattern.MatchString(migrationName)
 }
+
+// Checksum returns the hex encoded SHA-256 checksum of data.
+func Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// FormatDuration rounds d for display, such as 12ms or 1.4s.
+func FormatDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
@@ -0,0 +1,225 @@
+package helpers
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var dollarQuoteTag = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+// SplitStatements splits a SQL script into its statements. Semicolons inside
+// comments, quoted strings and identifiers, PostgreSQL dollar-quoted bodies and
+// SQLite trigger bodies do not end a statement. Comments that precede a
+// statement, or follow it on the same line, are kept with that statement.
+// Chunks that only contain comments are dropped.
+func SplitStatements(query string) []string {
+	var statements []string
+
+	add := func(statement string) {
+		statement = strings.TrimSpace(statement)
+		if strings.TrimSpace(StripSQLComments(statement)) != "" {
+			statements = append(statements, statement)
+		}
+	}
+
+	start := 0
+	depth := 0
+	var words []string
+
+	for i := 0; i < len(query); {
+		next := skipSQLToken(query, i)
+		if next > i {
+			i = next
+			continue
+		}
+
+		c := query[i]
+		switch {
+		case isWordByte(c):
+			j := i
+			for j < len(query) && isWordByte(query[j]) {
+				j++
+			}
+			word := strings.ToUpper(query[i:j])
+			if len(words) < 3 {
+				words = append(words, word)
+			}
+			if isTriggerStatement(words) {
+				switch word {
+				case "BEGIN", "CASE":
+					depth++
+				case "END":
+					if depth > 0 {
+						depth--
+					}
+				}
+			}
+			i = j
+		case c == ';' && depth == 0:
+			end := i + 1
+			j := end
+			for j < len(query) && (query[j] == ' ' || query[j] == '\t') {
+				j++
+			}
+			if strings.HasPrefix(query[j:], "--") {
+				for j < len(query) && query[j] != '\n' {
+					j++
+				}
+				end = j
+			}
+			add(query[start:end])
+			start = end
+			words = nil
+			i = end
+		default:
+			i++
+		}
+	}
+
+	add(query[start:])
+
+	return statements
+}
+
+// StripSQLComments removes line and block comments from query, leaving quoted
+// strings and identifiers untouched.
+func StripSQLComments(query string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(query); {
+		next := skipSQLToken(query, i)
+		if next > i {
+			if !strings.HasPrefix(query[i:], "--") && !strings.HasPrefix(query[i:], "/*") {
+				b.WriteString(query[i:next])
+			} else {
+				b.WriteByte(' ')
+			}
+			i = next
+			continue
+		}
+		b.WriteByte(query[i])
+		i++
+	}
+
+	return b.String()
+}
+
+// SQLComments returns the text of every line and block comment in query.
+func SQLComments(query string) []string {
+	var comments []string
+
+	for i := 0; i < len(query); {
+		next := skipSQLToken(query, i)
+		if next > i {
+			switch {
+			case strings.HasPrefix(query[i:], "--"):
+				comments = append(comments, strings.TrimSpace(query[i+2:next]))
+			case strings.HasPrefix(query[i:], "/*"):
+				comments = append(comments, strings.TrimSpace(strings.TrimSuffix(query[i+2:next], "*/")))
+			}
+			i = next
+			continue
+		}
+		i++
+	}
+
+	return comments
+}
+
+type Directive struct {
+	Name  string
+	Value string
+}
+
+// ParseHeaderDirectives returns the "-- miflo:<name> <value>" comments at the
+// top of a SQL file, before the first statement.
+func ParseHeaderDirectives(query string) []Directive {
+	var directives []Directive
+
+	for _, line := range strings.Split(query, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+
+		comment := strings.TrimSpace(strings.TrimPrefix(line, "--"))
+		if !strings.HasPrefix(comment, "miflo:") {
+			continue
+		}
+
+		name, value, _ := strings.Cut(strings.TrimPrefix(comment, "miflo:"), " ")
+		directives = append(directives, Directive{Name: name, Value: strings.TrimSpace(value)})
+	}
+
+	return directives
+}
+
+// skipSQLToken returns the index just past the comment, quoted string,
+// quoted identifier or dollar-quoted body starting at i, or i if there is
+// none.
+func skipSQLToken(query string, i int) int {
+	rest := query[i:]
+
+	switch {
+	case strings.HasPrefix(rest, "--"):
+		if end := strings.IndexByte(rest, '\n'); end >= 0 {
+			return i + end
+		}
+		return len(query)
+	case strings.HasPrefix(rest, "/*"):
+		if end := strings.Index(rest[2:], "*/"); end >= 0 {
+			return i + 2 + end + 2
+		}
+		return len(query)
+	case rest[0] == '\'' || rest[0] == '"' || rest[0] == '`':
+		return skipQuoted(query, i, rest[0])
+	case rest[0] == '[':
+		if end := strings.IndexByte(rest, ']'); end >= 0 {
+			return i + end + 1
+		}
+		return len(query)
+	case rest[0] == '$' && (i == 0 || !isWordByte(query[i-1])):
+		tag := dollarQuoteTag.FindString(rest)
+		if tag == "" {
+			return i
+		}
+		if end := strings.Index(rest[len(tag):], tag); end >= 0 {
+			return i + len(tag) + end + len(tag)
+		}
+		return len(query)
+	}
+
+	return i
+}
+
+func skipQuoted(query string, i int, quote byte) int {
+	for j := i + 1; j < len(query); j++ {
+		if query[j] != quote {
+			continue
+		}
+		if j+1 < len(query) && query[j+1] == quote {
+			j++
+			continue
+		}
+		return j + 1
+	}
+	return len(query)
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 0x80 || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+func isTriggerStatement(words []string) bool {
+	if len(words) < 2 || words[0] != "CREATE" {
+		return false
+	}
+	if words[1] == "TRIGGER" {
+		return true
+	}
+	return len(words) > 2 && (words[1] == "TEMP" || words[1] == "TEMPORARY") && words[2] == "TRIGGER"
+}
@@ -0,0 +1,60 @@
+package helpers
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// secretParams are query parameters that hold credentials in database URLs.
+var secretParams = []string{"password", "authToken", "auth_token", "token", "sslpassword"}
+
+// RedactURL returns databaseURL with its password and credential query
+// parameters replaced, so it can be printed.
+func RedactURL(databaseURL string) string {
+	u, err := url.Parse(databaseURL)
+	if err != nil || u.Opaque != "" {
+		return databaseURL
+	}
+
+	redacted := u.Redacted()
+
+	query := u.Query()
+	changed := false
+	for key := range query {
+		for _, secret := range secretParams {
+			if strings.EqualFold(key, secret) {
+				query.Set(key, "xxxxx")
+				changed = true
+			}
+		}
+	}
+
+	if !changed {
+		return redacted
+	}
+
+	u, _ = url.Parse(redacted)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+var (
+	urlPattern         = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"'<>]+`)
+	secretParamPattern = regexp.MustCompile(`(?i)\b(password|authToken|auth_token|token|sslpassword)=([^\s&"']+)`)
+)
+
+// RedactSecrets redacts every database URL in s with RedactURL, and the
+// values of credential parameters outside of URLs, such as the password of a
+// PostgreSQL "host=... password=..." connection string. It is used on
+// messages, such as driver errors, that may contain a connection string.
+func RedactSecrets(s string) string {
+	s = urlPattern.ReplaceAllStringFunc(s, RedactURL)
+	return secretParamPattern.ReplaceAllStringFunc(s, func(param string) string {
+		key, value, _ := strings.Cut(param, "=")
+		if value == "xxxxx" {
+			return param
+		}
+		return key + "=xxxxx"
+	})
+}
@@ -0,0 +1,172 @@
+// Package logging builds the slog loggers miflo writes its diagnostics to.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/gavsidhu/miflo/internal/helpers"
+)
+
+// LevelTrace is below slog.LevelDebug. The statements miflo runs on its own
+// tables are logged at this level.
+const LevelTrace = slog.LevelDebug - 4
+
+// New returns a logger that writes records of level and above to w, either as
+// "text" for people or as "json". Connection strings are redacted from every
+// record.
+func New(w io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = &textHandler{mu: &sync.Mutex{}, w: w, level: level}
+	case "json":
+		handler = slog.NewJSONHandler(w, &slog.HandlerOptions{
+			Level: level,
+			ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+				if attr.Key == slog.LevelKey && attr.Value.Any() == LevelTrace {
+					return slog.String(slog.LevelKey, "TRACE")
+				}
+				return attr
+			},
+		})
+	default:
+		return nil, fmt.Errorf("invalid log format %q, expected text or json", format)
+	}
+
+	return slog.New(&redactHandler{handler}), nil
+}
+
+// redactHandler redacts connection strings from the message and attributes
+// of records before passing them on.
+type redactHandler struct {
+	next slog.Handler
+}
+
+func (h *redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, helpers.RedactSecrets(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(attr))
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redacted[i] = redactAttr(attr)
+	}
+	return &redactHandler{h.next.WithAttrs(redacted)}
+}
+
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{h.next.WithGroup(name)}
+}
+
+func redactAttr(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, helpers.RedactSecrets(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		redacted := make([]any, len(group))
+		for i, member := range group {
+			redacted[i] = redactAttr(member)
+		}
+		return slog.Group(attr.Key, redacted...)
+	case slog.KindAny:
+		if err, ok := value.Any().(error); ok {
+			return slog.String(attr.Key, helpers.RedactSecrets(err.Error()))
+		}
+	}
+	return slog.Attr{Key: attr.Key, Value: value}
+}
+
+// textHandler writes records as plain lines. Info records are written as
+// their message alone, because miflo phrases them for people and their
+// attributes are meant for the JSON format. Other levels are prefixed with
+// the level and followed by their attributes as key=value pairs.
+type textHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level slog.Level
+	attrs []slog.Attr
+}
+
+func (h *textHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *textHandler) Handle(ctx context.Context, record slog.Record) error {
+	var line strings.Builder
+
+	color := ""
+	switch {
+	case record.Level >= slog.LevelError:
+		color = helpers.ColorRed
+		line.WriteString("error: ")
+	case record.Level >= slog.LevelWarn:
+		color = helpers.ColorYellow
+		line.WriteString("warning: ")
+	case record.Level < slog.LevelInfo:
+		line.WriteString("debug: ")
+	}
+	line.WriteString(record.Message)
+
+	attrs := h.attrs
+	if record.Level != slog.LevelInfo {
+		record.Attrs(func(attr slog.Attr) bool {
+			attrs = append(attrs, attr)
+			return true
+		})
+	}
+	for _, attr := range attrs {
+		writeAttr(&line, "", attr)
+	}
+
+	out := line.String()
+	if color != "" {
+		out = color + out + helpers.ColorReset
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := fmt.Fprintln(h.w, out)
+	return err
+}
+
+func writeAttr(line *strings.Builder, prefix string, attr slog.Attr) {
+	value := attr.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		for _, member := range value.Group() {
+			writeAttr(line, prefix+attr.Key+".", member)
+		}
+		return
+	}
+
+	text := value.String()
+	if strings.ContainsAny(text, " \t\n\"=") || text == "" {
+		text = fmt.Sprintf("%q", text)
+	}
+	fmt.Fprintf(line, " %s%s=%s", prefix, attr.Key, text)
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &textHandler{mu: h.mu, w: h.w, level: h.level, attrs: append(append([]slog.Attr(nil), h.attrs...), attrs...)}
+}
+
+// WithGroup is not supported by the text format, attributes of groups are
+// written without the group name.
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	return h
+}
@@ -0,0 +1,314 @@
+// Package metrics keeps the migration state of target databases and writes it
+// in the OpenMetrics text format, as read by Prometheus and by the textfile
+// collector of node_exporter.
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gavsidhu/miflo/internal/miflo"
+)
+
+// ContentType is the media type of the exposition written by WriteTo.
+const ContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// Target is the migration state of one target database.
+type Target struct {
+	Applied   int
+	Pending   int
+	LastBatch int
+	// LastRunDuration is how long the most recent up or revert took, whether
+	// it succeeded or not.
+	LastRunDuration time.Duration
+	// LastSuccess is when the most recent successful run ended.
+	LastSuccess time.Time
+	Failures    int
+}
+
+// Registry holds the state of every target, keyed by target name. It is safe
+// for concurrent use.
+type Registry struct {
+	mu      sync.Mutex
+	targets map[string]*Target
+}
+
+func NewRegistry() *Registry {
+	return &Registry{targets: map[string]*Target{}}
+}
+
+// Record updates the state of target with the outcome of a run.
+func (r *Registry) Record(target string, summary miflo.RunSummary) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t := r.target(target)
+	t.LastRunDuration = summary.Duration
+	if summary.Err != nil {
+		t.Failures++
+	} else {
+		t.LastSuccess = summary.Start.Add(summary.Duration)
+	}
+
+	if summary.Status != nil {
+		t.Applied = summary.Status.Applied
+		t.Pending = len(summary.Status.Pending)
+		t.LastBatch = summary.LastBatch
+	}
+}
+
+// SetStatus updates the applied and pending counts and the latest batch of
+// target without recording a run.
+func (r *Registry) SetStatus(target string, status miflo.MigrationStatus, lastBatch int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t := r.target(target)
+	t.Applied = status.Applied
+	t.Pending = len(status.Pending)
+	t.LastBatch = lastBatch
+}
+
+func (r *Registry) target(name string) *Target {
+	t, ok := r.targets[name]
+	if !ok {
+		t = &Target{}
+		r.targets[name] = t
+	}
+	return t
+}
+
+// metric is a metric family of the exposition, with its value for a target.
+type metric struct {
+	name  string
+	kind  string
+	unit  string
+	help  string
+	value func(t *Target) float64
+	set   func(t *Target, value float64)
+}
+
+var metricFamilies = []metric{
+	{
+		name:  "miflo_migrations_applied",
+		kind:  "gauge",
+		help:  "Number of applied migrations.",
+		value: func(t *Target) float64 { return float64(t.Applied) },
+		set:   func(t *Target, value float64) { t.Applied = int(value) },
+	},
+	{
+		name:  "miflo_migrations_pending",
+		kind:  "gauge",
+		help:  "Number of migrations in the migrations folder that are not applied.",
+		value: func(t *Target) float64 { return float64(t.Pending) },
+		set:   func(t *Target, value float64) { t.Pending = int(value) },
+	},
+	{
+		name:  "miflo_last_batch",
+		kind:  "gauge",
+		help:  "Number of the latest batch of applied migrations.",
+		value: func(t *Target) float64 { return float64(t.LastBatch) },
+		set:   func(t *Target, value float64) { t.LastBatch = int(value) },
+	},
+	{
+		name:  "miflo_last_run_duration_seconds",
+		kind:  "gauge",
+		unit:  "seconds",
+		help:  "Duration of the most recent up or revert.",
+		value: func(t *Target) float64 { return t.LastRunDuration.Seconds() },
+		set:   func(t *Target, value float64) { t.LastRunDuration = time.Duration(value * float64(time.Second)) },
+	},
+	{
+		name: "miflo_last_success_timestamp_seconds",
+		kind: "gauge",
+		unit: "seconds",
+		help: "Unix time the most recent successful up or revert ended, 0 if none succeeded.",
+		value: func(t *Target) float64 {
+			if t.LastSuccess.IsZero() {
+				return 0
+			}
+			return float64(t.LastSuccess.UnixMilli()) / 1000
+		},
+		set: func(t *Target, value float64) {
+			if value > 0 {
+				t.LastSuccess = time.UnixMilli(int64(value * 1000))
+			}
+		},
+	},
+	{
+		name:  "miflo_run_failures",
+		kind:  "counter",
+		help:  "Number of up and revert runs that failed.",
+		value: func(t *Target) float64 { return float64(t.Failures) },
+		set:   func(t *Target, value float64) { t.Failures = int(value) },
+	},
+}
+
+// sample returns the name of the samples of the family.
+func (m metric) sample() string {
+	if m.kind == "counter" {
+		return m.name + "_total"
+	}
+	return m.name
+}
+
+// WriteTo writes the state of every target in the OpenMetrics text format,
+// with the target as the "target" label.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.targets))
+	for name := range r.targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, m := range metricFamilies {
+		fmt.Fprintf(&b, "# TYPE %s %s\n", m.name, m.kind)
+		if m.unit != "" {
+			fmt.Fprintf(&b, "# UNIT %s %s\n", m.name, m.unit)
+		}
+		fmt.Fprintf(&b, "# HELP %s %s\n", m.name, m.help)
+		for _, name := range names {
+			fmt.Fprintf(&b, "%s{target=\"%s\"} %s\n", m.sample(), escapeLabel(name), strconv.FormatFloat(m.value(r.targets[name]), 'f', -1, 64))
+		}
+	}
+	b.WriteString("# EOF\n")
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// WriteFile writes the metrics to path, replacing it at once so a collector
+// never reads a partial file.
+func (r *Registry) WriteFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".miflo-metrics-*")
+	if err != nil {
+		return fmt.Errorf("error writing metrics: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := r.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing metrics: %w", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing metrics: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing metrics: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error writing metrics: %w", err)
+	}
+
+	return nil
+}
+
+// ReadFile returns a registry with the state written to path by WriteFile, so
+// counters and timestamps carry over from one run to the next. It returns an
+// empty registry when path does not exist.
+func ReadFile(path string) (*Registry, error) {
+	r := NewRegistry()
+
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return r, nil
+		}
+		return nil, fmt.Errorf("error reading metrics: %w", err)
+	}
+
+	defer file.Close()
+
+	samples := map[string]metric{}
+	for _, m := range metricFamilies {
+		samples[m.sample()] = m
+	}
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		name, target, value, err := parseSample(text)
+		if err != nil {
+			return nil, fmt.Errorf("error reading metrics: %s:%d: %w", path, line, err)
+		}
+
+		if m, ok := samples[name]; ok {
+			m.set(r.target(target), value)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading metrics: %w", err)
+	}
+
+	return r, nil
+}
+
+// parseSample parses a sample line written by WriteTo, such as
+// miflo_last_batch{target="sqlite:app.db"} 3.
+func parseSample(line string) (name string, target string, value float64, err error) {
+	name, rest, ok := strings.Cut(line, `{target="`)
+	if !ok {
+		return "", "", 0, fmt.Errorf("expected a target label in %q", line)
+	}
+
+	var label strings.Builder
+	escaped := false
+	end := -1
+	for i, c := range rest {
+		switch {
+		case escaped:
+			switch c {
+			case 'n':
+				label.WriteRune('\n')
+			default:
+				label.WriteRune(c)
+			}
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			end = i
+		default:
+			label.WriteRune(c)
+		}
+		if end >= 0 {
+			break
+		}
+	}
+
+	if end < 0 || !strings.HasPrefix(rest[end:], `"} `) {
+		return "", "", 0, fmt.Errorf("invalid target label in %q", line)
+	}
+
+	value, err = strconv.ParseFloat(strings.TrimSpace(rest[end+len(`"} `):]), 64)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("invalid value in %q", line)
+	}
+
+	return name, label.String(), value, nil
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
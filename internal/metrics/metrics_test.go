@@ -0,0 +1,87 @@
+package metrics_test
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/metrics"
+	"github.com/gavsidhu/miflo/internal/miflo"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeMigration(t *testing.T, cwd string, name string, up string) {
+	t.Helper()
+
+	dir := path.Join(cwd, "migrations", name)
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	assert.NoError(t, os.WriteFile(path.Join(dir, "up.sql"), []byte(up), 0644))
+	assert.NoError(t, os.WriteFile(path.Join(dir, "down.sql"), []byte("SELECT 1;"), 0644))
+}
+
+func TestMetricsFile(t *testing.T) {
+	ctx := context.Background()
+	cwd := t.TempDir()
+	metricsFile := path.Join(cwd, "miflo.prom")
+
+	writeMigration(t, cwd, "1_create_users", "CREATE TABLE users (id INTEGER PRIMARY KEY);")
+	writeMigration(t, cwd, "2_create_posts", "CREATE TABLE posts (id INTEGER PRIMARY KEY);")
+
+	db, err := database.NewDatabase("sqlite:" + path.Join(cwd, "metrics.db"))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	registry, err := metrics.ReadFile(metricsFile)
+	assert.NoError(t, err)
+
+	record := miflo.WithRunSummary(func(summary miflo.RunSummary) {
+		registry.Record(`sqlite:"app".db`, summary)
+	})
+
+	assert.NoError(t, miflo.ApplyMigrations(db, ctx, cwd, record))
+	assert.NoError(t, registry.WriteFile(metricsFile))
+
+	data, err := os.ReadFile(metricsFile)
+	assert.NoError(t, err)
+	exposition := string(data)
+
+	assert.Contains(t, exposition, "# TYPE miflo_migrations_applied gauge\n")
+	assert.Contains(t, exposition, `miflo_migrations_applied{target="sqlite:\"app\".db"} 2`+"\n")
+	assert.Contains(t, exposition, `miflo_migrations_pending{target="sqlite:\"app\".db"} 0`+"\n")
+	assert.Contains(t, exposition, `miflo_last_batch{target="sqlite:\"app\".db"} 1`+"\n")
+	assert.Contains(t, exposition, "# TYPE miflo_run_failures counter\n")
+	assert.Contains(t, exposition, `miflo_run_failures_total{target="sqlite:\"app\".db"} 0`+"\n")
+	assert.NotContains(t, exposition, `miflo_last_success_timestamp_seconds{target="sqlite:\"app\".db"} 0`+"\n")
+	assert.True(t, strings.HasSuffix(exposition, "# EOF\n"))
+
+	writeMigration(t, cwd, "3_create_tags", "CREATE TABLE users (id INTEGER PRIMARY KEY);")
+
+	registry, err = metrics.ReadFile(metricsFile)
+	assert.NoError(t, err)
+	assert.Error(t, miflo.ApplyMigrations(db, ctx, cwd, record))
+	assert.Error(t, miflo.ApplyMigrations(db, ctx, cwd, record))
+	assert.NoError(t, registry.WriteFile(metricsFile))
+
+	registry, err = metrics.ReadFile(metricsFile)
+	assert.NoError(t, err)
+
+	var out strings.Builder
+	_, err = registry.WriteTo(&out)
+	assert.NoError(t, err)
+
+	assert.Contains(t, out.String(), `miflo_migrations_applied{target="sqlite:\"app\".db"} 2`+"\n")
+	assert.Contains(t, out.String(), `miflo_migrations_pending{target="sqlite:\"app\".db"} 1`+"\n")
+	assert.Contains(t, out.String(), `miflo_run_failures_total{target="sqlite:\"app\".db"} 2`+"\n")
+	assert.NotContains(t, out.String(), `miflo_last_success_timestamp_seconds{target="sqlite:\"app\".db"} 0`+"\n")
+
+	entries, err := os.ReadDir(cwd)
+	assert.NoError(t, err)
+	for _, entry := range entries {
+		assert.False(t, strings.HasPrefix(entry.Name(), ".miflo-metrics-"), "temporary file %s left behind", entry.Name())
+	}
+}
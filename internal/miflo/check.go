@@ -0,0 +1,118 @@
+package miflo
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/helpers"
+)
+
+// CheckStatus summarizes a CheckResult, from best to worst.
+type CheckStatus string
+
+const (
+	CheckUpToDate CheckStatus = "up_to_date"
+	CheckPending  CheckStatus = "pending"
+	CheckDrift    CheckStatus = "drift"
+	CheckError    CheckStatus = "error"
+)
+
+// CheckResult is how the database compares to the migrations directory. It is
+// written as JSON by miflo check --json.
+type CheckResult struct {
+	Status            CheckStatus `json:"status"`
+	Applied           int         `json:"applied"`
+	Pending           []string    `json:"pending"`
+	OutOfOrder        []string    `json:"out_of_order"`
+	PendingRepeatable []string    `json:"pending_repeatable"`
+	// Dirty lists the migrations that failed halfway outside of a
+	// transaction.
+	Dirty []string `json:"dirty"`
+	// ChecksumMismatch lists the applied migrations whose up SQL changed
+	// after they were applied.
+	ChecksumMismatch []string `json:"checksum_mismatch"`
+	// Missing lists the applied migrations whose directory no longer exists.
+	Missing []string `json:"missing"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// CheckMigrations compares the database with the migrations directory without
+// changing either. The Status of the result is CheckDrift when migrations are
+// dirty, changed or missing, CheckPending when migrations or repeatable
+// migrations are pending and CheckUpToDate otherwise.
+func CheckMigrations(db database.Database, ctx context.Context, cwd string, opts ...Option) (CheckResult, error) {
+	o := newOptions(opts)
+
+	state, err := getPendingState(db, ctx, cwd, o)
+	if err != nil {
+		return CheckResult{}, err
+	}
+
+	result := CheckResult{
+		Applied:           len(state.applied),
+		Pending:           append([]string{}, state.pending...),
+		OutOfOrder:        append([]string{}, state.outOfOrder...),
+		PendingRepeatable: []string{},
+		Dirty:             append([]string{}, state.dirty...),
+		ChecksumMismatch:  []string{},
+	}
+
+	for _, repeatable := range state.repeatables {
+		result.PendingRepeatable = append(result.PendingRepeatable, path.Join(helpers.RepeatableDir, repeatable.Name))
+	}
+
+	dirMigrations, err := helpers.GetDirMigrations(cwd)
+	if err != nil {
+		return CheckResult{}, err
+	}
+
+	onDisk := make(map[string]bool)
+	for _, migration := range dirMigrations {
+		onDisk[migration] = true
+	}
+
+	result.Missing, err = missingMigrations(cwd, onDisk, state.applied)
+	if err != nil {
+		return CheckResult{}, err
+	}
+	if result.Missing == nil {
+		result.Missing = []string{}
+	}
+	helpers.SortDirMigrations(result.Missing, true)
+
+	checksums, err := db.GetAppliedChecksums(ctx)
+	if err != nil {
+		return CheckResult{}, err
+	}
+
+	applied := append([]string(nil), state.applied...)
+	helpers.SortDirMigrations(applied, true)
+
+	for _, migration := range applied {
+		checksum, ok := checksums[migration]
+		if !ok || !onDisk[migration] {
+			continue
+		}
+
+		query, err := migrationSQL(cwd, migration, Up, db.Dialect().Name(), o.vars)
+		if err != nil {
+			return CheckResult{}, fmt.Errorf("error checking migration %s: %w", migration, err)
+		}
+		if helpers.Checksum([]byte(query)) != checksum {
+			result.ChecksumMismatch = append(result.ChecksumMismatch, migration)
+		}
+	}
+
+	switch {
+	case len(result.Dirty) > 0 || len(result.ChecksumMismatch) > 0 || len(result.Missing) > 0:
+		result.Status = CheckDrift
+	case len(result.Pending) > 0 || len(result.PendingRepeatable) > 0:
+		result.Status = CheckPending
+	default:
+		result.Status = CheckUpToDate
+	}
+
+	return result, nil
+}
@@ -30,8 +30,6 @@ func CreateMigration(migrationName string, cwd string, timestamp int64) error {
 		return err
 	}
 
-	fmt.Println("Migration created successfully")
-
 	return nil
 
 }
@@ -0,0 +1,279 @@
+package miflo
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/helpers"
+)
+
+type SchemaDifference struct {
+	// Object names the object that differs, such as "table users" or
+	// "column users.email".
+	Object string
+	// Kind is "missing" when the object only exists in the schema built from
+	// the migrations, "extra" when it only exists in the database and
+	// "changed" when it exists in both but differs.
+	Kind     string
+	Expected string
+	Actual   string
+}
+
+func (d SchemaDifference) String() string {
+	switch d.Kind {
+	case "missing":
+		return fmt.Sprintf("missing %s", d.Object)
+	case "extra":
+		return fmt.Sprintf("extra %s", d.Object)
+	default:
+		return fmt.Sprintf("changed %s: migrations have %q, database has %q", d.Object, d.Expected, d.Actual)
+	}
+}
+
+// DiffDatabase replays every migration, followed by the repeatable
+// migrations, into the scratch database at scratchURL and compares its schema
+// with the schema of db. For PostgreSQL, the migrations run in a temporary
+// schema of scratchURL, which may be the database of db, see
+// database.NewScratchDatabase. The number of migrations that
+// are pending on db is returned as well, since pending migrations show up as
+// differences.
+func DiffDatabase(ctx context.Context, db database.Database, scratchURL string, cwd string, opts ...Option) ([]SchemaDifference, int, error) {
+	o := newOptions(opts)
+
+	scratchDialect, err := database.DialectName(scratchURL)
+	if err != nil {
+		return nil, 0, err
+	}
+	if (scratchDialect == "postgres") != (db.Dialect().Name() == "postgres") {
+		return nil, 0, fmt.Errorf("the scratch database is %s but the database is %s", scratchDialect, db.Dialect().Name())
+	}
+
+	migrations, err := helpers.GetDirMigrations(cwd)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	helpers.SortDirMigrations(migrations, true)
+
+	pendingMigrations, err := db.GetUnappliedMigrations(ctx, cwd)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error retrieving unapplied migrations: %w", err)
+	}
+
+	appliedMigrations, err := getAppliedMigrations(ctx, db)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pendingMigrations, _, err = resolveSquashedMigrations(cwd, appliedMigrations, pendingMigrations)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pendingRepeatables, err := PendingRepeatableMigrations(ctx, db, cwd, o.vars)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	repeatables, err := GetRepeatableMigrations(cwd, o.vars)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	scratch, cleanup, err := database.NewScratchDatabase(scratchURL)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error setting up scratch database: %w", err)
+	}
+
+	defer cleanup()
+
+	if err := replayMigrations(ctx, scratch, cwd, migrations, repeatables, db.Dialect().Name(), o); err != nil {
+		return nil, 0, err
+	}
+
+	expected, err := scratch.Introspect(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error reading scratch database schema: %w", err)
+	}
+
+	actual, err := db.Introspect(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error reading database schema: %w", err)
+	}
+
+	return DiffSchemas(expected, actual), len(pendingMigrations) + len(pendingRepeatables), nil
+}
+
+// DiffSchemas compares the schema the migrations produce with the schema of
+// a database.
+func DiffSchemas(expected *database.Schema, actual *database.Schema) []SchemaDifference {
+	var differences []SchemaDifference
+
+	expectedTables := make(map[string]database.Table)
+	for _, table := range expected.Tables {
+		expectedTables[table.Name] = table
+	}
+
+	actualTables := make(map[string]database.Table)
+	for _, table := range actual.Tables {
+		actualTables[table.Name] = table
+	}
+
+	for _, name := range sortedKeys(expectedTables, actualTables) {
+		expectedTable, inExpected := expectedTables[name]
+		actualTable, inActual := actualTables[name]
+		switch {
+		case !inActual:
+			differences = append(differences, SchemaDifference{Object: "table " + name, Kind: "missing"})
+		case !inExpected:
+			differences = append(differences, SchemaDifference{Object: "table " + name, Kind: "extra"})
+		default:
+			differences = append(differences, diffTables(expectedTable, actualTable)...)
+		}
+	}
+
+	differences = append(differences, diffIndexes(expected.Indexes, actual.Indexes)...)
+	differences = append(differences, diffObjects("type", expected.Types, actual.Types)...)
+	differences = append(differences, diffObjects("sequence", expected.Sequences, actual.Sequences)...)
+	differences = append(differences, diffObjects("view", expected.Views, actual.Views)...)
+	differences = append(differences, diffObjects("function", expected.Functions, actual.Functions)...)
+	differences = append(differences, diffObjects("trigger", expected.Triggers, actual.Triggers)...)
+
+	return differences
+}
+
+func diffTables(expected database.Table, actual database.Table) []SchemaDifference {
+	var differences []SchemaDifference
+
+	expectedColumns := make(map[string]database.Column)
+	for _, column := range expected.Columns {
+		expectedColumns[column.Name] = column
+	}
+
+	actualColumns := make(map[string]database.Column)
+	for _, column := range actual.Columns {
+		actualColumns[column.Name] = column
+	}
+
+	for _, name := range sortedKeys(expectedColumns, actualColumns) {
+		object := fmt.Sprintf("column %s.%s", expected.Name, name)
+		expectedColumn, inExpected := expectedColumns[name]
+		actualColumn, inActual := actualColumns[name]
+		switch {
+		case !inActual:
+			differences = append(differences, SchemaDifference{Object: object, Kind: "missing"})
+		case !inExpected:
+			differences = append(differences, SchemaDifference{Object: object, Kind: "extra"})
+		default:
+			if !strings.EqualFold(expectedColumn.Type, actualColumn.Type) {
+				differences = append(differences, SchemaDifference{object + " type", "changed", expectedColumn.Type, actualColumn.Type})
+			}
+			if expectedColumn.NotNull != actualColumn.NotNull {
+				differences = append(differences, SchemaDifference{object + " nullability", "changed", nullability(expectedColumn.NotNull), nullability(actualColumn.NotNull)})
+			}
+			if normalizeDefinition(expectedColumn.Default) != normalizeDefinition(actualColumn.Default) {
+				differences = append(differences, SchemaDifference{object + " default", "changed", expectedColumn.Default, actualColumn.Default})
+			}
+			if normalizeDefinition(expectedColumn.Extra) != normalizeDefinition(actualColumn.Extra) {
+				differences = append(differences, SchemaDifference{object + " definition", "changed", expectedColumn.Extra, actualColumn.Extra})
+			}
+		}
+	}
+
+	// Constraint names are often generated, so constraints are matched by
+	// their definition.
+	expectedConstraints := make(map[string]bool)
+	for _, constraint := range expected.Constraints {
+		expectedConstraints[normalizeDefinition(constraint.Definition)] = true
+	}
+
+	actualConstraints := make(map[string]bool)
+	for _, constraint := range actual.Constraints {
+		actualConstraints[normalizeDefinition(constraint.Definition)] = true
+	}
+
+	for _, definition := range sortedKeys(expectedConstraints, actualConstraints) {
+		object := fmt.Sprintf("constraint on %s: %s", expected.Name, definition)
+		switch {
+		case !actualConstraints[definition]:
+			differences = append(differences, SchemaDifference{Object: object, Kind: "missing"})
+		case !expectedConstraints[definition]:
+			differences = append(differences, SchemaDifference{Object: object, Kind: "extra"})
+		}
+	}
+
+	return differences
+}
+
+func diffIndexes(expected []database.Index, actual []database.Index) []SchemaDifference {
+	expectedObjects := make([]database.SchemaObject, 0, len(expected))
+	for _, index := range expected {
+		expectedObjects = append(expectedObjects, database.SchemaObject{Name: index.Name, Definition: index.Definition})
+	}
+
+	actualObjects := make([]database.SchemaObject, 0, len(actual))
+	for _, index := range actual {
+		actualObjects = append(actualObjects, database.SchemaObject{Name: index.Name, Definition: index.Definition})
+	}
+
+	return diffObjects("index", expectedObjects, actualObjects)
+}
+
+func diffObjects(kind string, expected []database.SchemaObject, actual []database.SchemaObject) []SchemaDifference {
+	var differences []SchemaDifference
+
+	expectedObjects := make(map[string]string)
+	for _, object := range expected {
+		expectedObjects[object.Name] = object.Definition
+	}
+
+	actualObjects := make(map[string]string)
+	for _, object := range actual {
+		actualObjects[object.Name] = object.Definition
+	}
+
+	for _, name := range sortedKeys(expectedObjects, actualObjects) {
+		expectedDefinition, inExpected := expectedObjects[name]
+		actualDefinition, inActual := actualObjects[name]
+		object := kind + " " + name
+		switch {
+		case !inActual:
+			differences = append(differences, SchemaDifference{Object: object, Kind: "missing"})
+		case !inExpected:
+			differences = append(differences, SchemaDifference{Object: object, Kind: "extra"})
+		case normalizeDefinition(expectedDefinition) != normalizeDefinition(actualDefinition):
+			differences = append(differences, SchemaDifference{object, "changed", expectedDefinition, actualDefinition})
+		}
+	}
+
+	return differences
+}
+
+func nullability(notNull bool) string {
+	if notNull {
+		return "NOT NULL"
+	}
+	return "NULL"
+}
+
+func normalizeDefinition(definition string) string {
+	return strings.ToLower(strings.Join(strings.Fields(definition), " "))
+}
+
+func sortedKeys[V any](maps ...map[string]V) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, m := range maps {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
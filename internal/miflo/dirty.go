@@ -0,0 +1,250 @@
+package miflo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/helpers"
+)
+
+// noTransactionDirective at the top of a migration file runs it outside of
+// the batch transaction, for statements such as CREATE INDEX CONCURRENTLY
+// that cannot run in one.
+const noTransactionDirective = "no-transaction"
+
+// DirtyMigration is a migration that failed halfway outside of a
+// transaction while it was applied or reverted, as Direction says.
+type DirtyMigration struct {
+	Name      string
+	Direction Direction
+}
+
+// DirtyError is returned while a migration that ran outside of a transaction
+// failed halfway and the database may be in an unknown state.
+type DirtyError struct {
+	Migrations []DirtyMigration
+}
+
+func (e *DirtyError) Error() string {
+	lines := []string{"the database is dirty, these migrations failed halfway outside of a transaction:"}
+	directions := make(map[Direction]bool)
+	for _, migration := range e.Migrations {
+		action := "applying"
+		if migration.Direction == Down {
+			action = "reverting"
+		}
+		lines = append(lines, fmt.Sprintf("  %s, while %s it", migration.Name, action))
+		directions[migration.Direction] = true
+	}
+
+	lines = append(lines, "fix the database by hand, then resolve each migration with miflo force")
+	if directions[Up] {
+		lines = append(lines, "  for a migration that failed while applying it, run \"miflo force <version> --clean\" if it left no changes behind or \"miflo force <version> --applied\" if it completed")
+	}
+	if directions[Down] {
+		lines = append(lines, "  for a migration that failed while reverting it, run \"miflo force <version> --clean\" if the revert left no changes behind, which keeps it applied, or \"miflo force <version> --applied\" if the revert completed, which records it as not applied")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checkDirty returns a DirtyError if the database has dirty migrations.
+func checkDirty(ctx context.Context, db database.Database) error {
+	dirty, err := getDirtyMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	if len(dirty) > 0 {
+		return &DirtyError{Migrations: dirty}
+	}
+
+	return nil
+}
+
+func getDirtyMigrations(ctx context.Context, db database.Database) ([]DirtyMigration, error) {
+	names, err := db.GetDirtyMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirty []DirtyMigration
+	for _, name := range names {
+		direction, err := db.DirtyDirection(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		dirty = append(dirty, DirtyMigration{Name: name, Direction: Direction(direction)})
+	}
+
+	return dirty, nil
+}
+
+// noTransaction reports whether query must run outside of a transaction.
+func noTransaction(query string) bool {
+	for _, directive := range helpers.ParseHeaderDirectives(query) {
+		if directive.Name == noTransactionDirective {
+			return true
+		}
+	}
+	return false
+}
+
+// execWithoutTransaction runs the statements of query one by one outside of
+// a transaction. They are sent separately because PostgreSQL runs several
+// statements sent at once in an implicit transaction.
+func execWithoutTransaction(ctx context.Context, db database.Database, logger *slog.Logger, query string) error {
+	for _, statement := range helpers.SplitStatements(query) {
+		if strings.TrimSpace(helpers.StripSQLComments(statement)) == "" {
+			continue
+		}
+		logger.DebugContext(ctx, "executing statement", "sql", strings.TrimSpace(statement))
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batchStep is a migration applied or reverted as part of a batch and the
+// SQL that does it.
+type batchStep struct {
+	migration string
+	query     string
+	// withoutTx is set for migrations that run outside of any transaction.
+	withoutTx bool
+	// unprotected is set for migrations the batch transaction cannot roll
+	// back, which are marked dirty while they run.
+	unprotected bool
+	// skip is set for reverted migrations that are forced out of the
+	// migrations table without running any SQL.
+	skip     bool
+	timeouts database.Timeouts
+}
+
+func newBatchStep(db database.Database, migration string, query string, o options) (batchStep, error) {
+	timeouts, err := migrationTimeouts(query, o.timeouts)
+	if err != nil {
+		return batchStep{}, fmt.Errorf("migration %s: %w", migration, err)
+	}
+
+	withoutTx := noTransaction(query)
+	return batchStep{
+		migration:   migration,
+		query:       query,
+		withoutTx:   withoutTx,
+		unprotected: withoutTx || !db.Dialect().TransactionalDDL(),
+		timeouts:    timeouts,
+	}, nil
+}
+
+// interruptedError reports which migration was running when ctx was canceled
+// and what became of it. dirty tells whether the migration was marked dirty
+// before it was interrupted.
+func interruptedError(ctx context.Context, direction Direction, step batchStep, dirty bool, err error) error {
+	if ctx.Err() == nil {
+		return err
+	}
+
+	action := "applying"
+	if direction == Down {
+		action = "reverting"
+	}
+
+	if dirty {
+		return fmt.Errorf("interrupted while %s migration %s, it ran without the protection of a transaction and is marked dirty: %w", action, step.migration, err)
+	}
+	return fmt.Errorf("interrupted while %s migration %s, the open transaction was rolled back: %w", action, step.migration, err)
+}
+
+// batchTx is the transaction a batch of migrations runs in. It is committed
+// and started again around migrations that are not protected by it, so a
+// failure in one of them does not lose the migrations before it.
+type batchTx struct {
+	ctx context.Context
+	db  database.Database
+	tx  *sql.Tx
+}
+
+func beginBatch(ctx context.Context, db database.Database) (*batchTx, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	return &batchTx{ctx: ctx, db: db, tx: tx}, nil
+}
+
+// split commits the work done so far and starts a new transaction.
+func (b *batchTx) split() error {
+	if err := b.commit(); err != nil {
+		return err
+	}
+
+	tx, err := b.db.BeginTx(b.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	b.tx = tx
+
+	return nil
+}
+
+func (b *batchTx) commit() error {
+	if err := b.tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+func (b *batchTx) rollback() {
+	b.tx.Rollback()
+}
+
+// ForceMigration resolves a dirty migration once the database has been fixed
+// by hand. version is the name of the migration or its timestamp. completed
+// tells whether the SQL the migration failed in completed or left no changes
+// behind: a migration that failed while being applied is recorded as applied
+// if it completed and as not applied otherwise, and one that failed while
+// being reverted is recorded as not applied if the revert completed and as
+// still applied otherwise. It returns the migration it resolved.
+func ForceMigration(db database.Database, ctx context.Context, cwd string, version string, completed bool, opts ...Option) (DirtyMigration, error) {
+	o := newOptions(opts)
+
+	release, err := db.Lock(ctx)
+	if err != nil {
+		return DirtyMigration{}, err
+	}
+	defer release()
+
+	dirty, err := getDirtyMigrations(ctx, db)
+	if err != nil {
+		return DirtyMigration{}, err
+	}
+
+	var migration DirtyMigration
+	for _, candidate := range dirty {
+		if candidate.Name == version || strings.HasPrefix(candidate.Name, version+"_") {
+			migration = candidate
+			break
+		}
+	}
+
+	if migration.Name == "" {
+		return DirtyMigration{}, fmt.Errorf("migration %s is not dirty", version)
+	}
+
+	checksum := ""
+	if completed && migration.Direction == Up {
+		query, err := migrationSQL(cwd, migration.Name, Up, db.Dialect().Name(), o.vars)
+		if err != nil {
+			return DirtyMigration{}, err
+		}
+		checksum = helpers.Checksum([]byte(query))
+	}
+
+	return migration, db.ResolveDirtyMigration(ctx, migration.Name, completed, checksum)
+}
@@ -0,0 +1,116 @@
+package miflo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Direction is the direction a migration is run in.
+type Direction string
+
+const (
+	Up   Direction = "up"
+	Down Direction = "down"
+)
+
+// HookInfo describes the run or migration a hook is called for. Migration is
+// empty for the before all and after all hooks.
+type HookInfo struct {
+	Migration string
+	Direction Direction
+	Batch     int
+}
+
+// HookFunc is called within the migration transaction. Returning an error
+// rolls the transaction back.
+type HookFunc func(ctx context.Context, tx *sql.Tx, info HookInfo) error
+
+// Hooks are called around the migrations applied by ApplyMigrations or
+// reverted by RevertMigrations. They run after the matching hook SQL file in
+// the migrations directory.
+type Hooks struct {
+	BeforeAll  HookFunc
+	AfterAll   HookFunc
+	BeforeEach HookFunc
+	AfterEach  HookFunc
+}
+
+// Hook SQL files in the migrations directory.
+const (
+	beforeAllHook  = "before_all.sql"
+	afterAllHook   = "after_all.sql"
+	beforeEachHook = "before_each.sql"
+	afterEachHook  = "after_each.sql"
+)
+
+var hookFiles = []string{beforeAllHook, afterAllHook, beforeEachHook, afterEachHook}
+
+func isHookFile(name string) bool {
+	for _, hookFile := range hookFiles {
+		if name == hookFile {
+			return true
+		}
+	}
+	return false
+}
+
+func WithHooks(hooks Hooks) Option {
+	return func(o *options) {
+		o.hooks = hooks
+	}
+}
+
+// runHook executes the hook SQL file, if it exists, followed by fn.
+func runHook(ctx context.Context, tx *sql.Tx, cwd string, hookFile string, fn HookFunc, info HookInfo, o options) error {
+	query, err := hookSQL(cwd, hookFile, o.vars)
+	if err != nil {
+		return err
+	}
+
+	if query != "" {
+		o.logger.DebugContext(ctx, "executing statement", "hook", hookFile, "sql", strings.TrimSpace(query))
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("error executing hook file %s: %w", path.Join(cwd, "migrations", hookFile), describeHook(err, info))
+		}
+	}
+
+	if fn != nil {
+		if err := fn(ctx, tx, info); err != nil {
+			return fmt.Errorf("error running %s hook: %w", strings.TrimSuffix(hookFile, ".sql"), describeHook(err, info))
+		}
+	}
+
+	return nil
+}
+
+// hookSQL returns the rendered hook SQL file, or an empty string if it does
+// not exist or is empty.
+func hookSQL(cwd string, hookFile string, vars map[string]string) (string, error) {
+	hookFilePath := path.Join(cwd, "migrations", hookFile)
+	if _, err := os.Stat(hookFilePath); errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+
+	query, err := readSQLFile(hookFilePath, vars)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.TrimSpace(query) == "" {
+		return "", nil
+	}
+
+	return query, nil
+}
+
+func describeHook(err error, info HookInfo) error {
+	if info.Migration == "" {
+		return err
+	}
+	return fmt.Errorf("%s (%s): %w", info.Migration, info.Direction, err)
+}
@@ -0,0 +1,136 @@
+package miflo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/helpers"
+)
+
+// irreversibleDirective marks a migration that cannot be reverted when it is
+// at the top of its up.sql, optionally followed by the reason:
+//
+//	-- miflo:irreversible drops the legacy audit data
+const irreversibleDirective = "irreversible"
+
+// WithForce makes RevertMigrations remove irreversible migrations, and
+// migrations with an empty down.sql, from the migrations table without
+// reverting them instead of refusing to revert the batch.
+func WithForce() Option {
+	return func(o *options) {
+		o.force = true
+	}
+}
+
+// irreversibleReason reports whether query is marked irreversible and the
+// reason given with the directive.
+func irreversibleReason(query string) (string, bool) {
+	for _, directive := range helpers.ParseHeaderDirectives(query) {
+		if directive.Name == irreversibleDirective {
+			return directive.Value, true
+		}
+	}
+	return "", false
+}
+
+// isIrreversible reports whether migration is irreversible on dialect,
+// because the up file it uses is marked irreversible or because it has no
+// down file.
+func isIrreversible(cwd string, migration string, dialect string) (bool, error) {
+	name := migrationFileName(cwd, migration, Up, dialect)
+	sqlBytes, err := os.ReadFile(path.Join(cwd, "migrations", migration, name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+
+	if _, irreversible := irreversibleReason(string(sqlBytes)); irreversible {
+		return true, nil
+	}
+
+	downName := migrationFileName(cwd, migration, Down, dialect)
+	if _, err := os.Stat(path.Join(cwd, "migrations", migration, downName)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	return false, nil
+}
+
+// planRevert reads and renders the down file of every migration before
+// anything is reverted, so a batch is either reverted completely or not at
+// all. Migrations that are irreversible, because their up.sql says so or
+// because they have no down file, or whose down file is empty are refused
+// unless force is set. Unreadable down files are always refused.
+func planRevert(cwd string, migrations []string, db database.Database, o options) ([]batchStep, error) {
+	dialect := db.Dialect().Name()
+
+	var steps []batchStep
+	var refused []string
+	var failed []string
+
+	for _, migration := range migrations {
+		upName := migrationFileName(cwd, migration, Up, dialect)
+		downName := migrationFileName(cwd, migration, Down, dialect)
+
+		reason := ""
+
+		upBytes, err := os.ReadFile(path.Join(cwd, "migrations", migration, upName))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			failed = append(failed, fmt.Sprintf("%s: %s", migration, err))
+			continue
+		}
+
+		// Irreversible migrations need no down file, so theirs is not read.
+		var query string
+		if why, irreversible := irreversibleReason(string(upBytes)); irreversible {
+			reason = "is irreversible"
+			if why != "" {
+				reason += ": " + why
+			}
+		} else {
+			query, err = readSQLFile(path.Join(cwd, "migrations", migration, downName), o.vars)
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				reason = fmt.Sprintf("is irreversible: it has no %s", downName)
+			case err != nil:
+				failed = append(failed, fmt.Sprintf("%s: %s", migration, err))
+				continue
+			case strings.TrimSpace(helpers.StripSQLComments(query)) == "":
+				reason = fmt.Sprintf("%s is empty", downName)
+			}
+		}
+
+		if reason != "" {
+			if !o.force {
+				refused = append(refused, fmt.Sprintf("%s %s", migration, reason))
+				continue
+			}
+			o.logger.Warn(fmt.Sprintf("%s %s, removing it from the migrations table without reverting it", migration, reason), "migration", migration)
+			steps = append(steps, batchStep{migration: migration, skip: true})
+			continue
+		}
+
+		step, err := newBatchStep(db, migration, query, o)
+		if err != nil {
+			failed = append(failed, err.Error())
+			continue
+		}
+		steps = append(steps, step)
+	}
+
+	if len(failed) > 0 {
+		return nil, fmt.Errorf("cannot revert the batch:\n  %s", strings.Join(append(failed, refused...), "\n  "))
+	}
+
+	if len(refused) > 0 {
+		return nil, fmt.Errorf("cannot revert the batch:\n  %s\nuse --force to remove them from the migrations table without reverting them", strings.Join(refused, "\n  "))
+	}
+
+	return steps, nil
+}
@@ -0,0 +1,291 @@
+package miflo
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/gavsidhu/miflo/internal/helpers"
+)
+
+const (
+	RuleDropWithoutDown       = "drop-without-down"
+	RuleNotNullWithoutDefault = "not-null-without-default"
+	RuleIndexNotConcurrent    = "index-without-concurrently"
+	RuleSQLiteTableRewrite    = "sqlite-table-rewrite"
+	RuleDownMissingIfExists   = "down-missing-if-exists"
+	RuleEmptyDown             = "empty-down"
+)
+
+type LintFinding struct {
+	Migration string
+	File      string
+	Rule      string
+	Message   string
+}
+
+func (f LintFinding) String() string {
+	return fmt.Sprintf("%s/%s: [%s] %s", f.Migration, f.File, f.Rule, f.Message)
+}
+
+var (
+	ignoreDirective   = regexp.MustCompile(`^miflo:ignore\s+(.+)$`)
+	createTablePrefix = regexp.MustCompile(`(?i)^CREATE\s+(?:(?:TEMP|TEMPORARY|UNLOGGED)\s+)?TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?([^\s(]+)`)
+	dropTablePrefix   = regexp.MustCompile(`(?i)^DROP\s+TABLE\s+(?:IF\s+EXISTS\s+)?(.+?)(?:\s+(?:CASCADE|RESTRICT))?$`)
+	alterTablePrefix  = regexp.MustCompile(`(?i)^ALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?(\S+)\s+(.+)$`)
+	dropColumnAction  = regexp.MustCompile(`(?i)^DROP\s+(?:COLUMN\s+)?(?:IF\s+EXISTS\s+)?(\S+)`)
+	addColumnAction   = regexp.MustCompile(`(?i)^ADD\s+(?:COLUMN\s+)?(?:IF\s+NOT\s+EXISTS\s+)?(\S+)(.*)$`)
+	renameTableAction = regexp.MustCompile(`(?i)^RENAME\s+TO\s+(\S+)$`)
+	createIndexPrefix = regexp.MustCompile(`(?i)^CREATE\s+(?:UNIQUE\s+)?INDEX\s+(CONCURRENTLY\s+)?.*?\bON\s+(?:ONLY\s+)?([^\s(]+)`)
+	insertSelect      = regexp.MustCompile(`(?i)^INSERT\s+INTO\s+([^\s(]+).*?\bSELECT\b.*?\bFROM\s+([^\s,;()]+)`)
+	dropObjectPrefix  = regexp.MustCompile(`(?i)^DROP\s+(TABLE|INDEX|VIEW|MATERIALIZED\s+VIEW|TRIGGER|SEQUENCE|TYPE|FUNCTION|SCHEMA)\s+(IF\s+EXISTS\s+)?`)
+	notNull           = regexp.MustCompile(`(?i)\bNOT\s+NULL\b`)
+	defaultClause     = regexp.MustCompile(`(?i)\bDEFAULT\b`)
+)
+
+var nonColumnKeywords = map[string]bool{
+	"constraint": true, "primary": true, "unique": true, "foreign": true, "check": true,
+	"exclude": true, "default": true, "not": true, "identity": true, "expression": true,
+}
+
+type lintStatement struct {
+	code    string
+	ignored map[string]bool
+}
+
+type lintFile struct {
+	name         string
+	statements   []lintStatement
+	ignored      map[string]bool
+	irreversible bool
+}
+
+// LintMigrations statically checks the up.sql and down.sql files of every
+// migration, or their variants for dialect, for operations that are risky on
+// dialect. A rule can be disabled for a statement with a
+// "-- miflo:ignore rule-name" comment before or on the same line as the
+// statement.
+func LintMigrations(cwd string, dialect string) ([]LintFinding, error) {
+	migrations, err := helpers.GetDirMigrations(cwd)
+	if err != nil {
+		return nil, err
+	}
+
+	helpers.SortDirMigrations(migrations, true)
+
+	var findings []LintFinding
+	for _, migration := range migrations {
+		up, err := readLintFile(cwd, migration, migrationFileName(cwd, migration, Up, dialect))
+		if err != nil {
+			return nil, err
+		}
+
+		down, err := readLintFile(cwd, migration, migrationFileName(cwd, migration, Down, dialect))
+		if err != nil {
+			return nil, err
+		}
+
+		findings = append(findings, lintMigration(migration, dialect, up, down)...)
+	}
+
+	return findings, nil
+}
+
+func readLintFile(cwd string, migration string, name string) (*lintFile, error) {
+	sqlBytes, err := os.ReadFile(path.Join(cwd, "migrations", migration, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading SQL file %s: %w", name, err)
+	}
+
+	_, irreversible := irreversibleReason(string(sqlBytes))
+	file := &lintFile{name: name, ignored: ignoredRules(string(sqlBytes)), irreversible: irreversible}
+	for _, statement := range helpers.SplitStatements(string(sqlBytes)) {
+		code := strings.Join(strings.Fields(helpers.StripSQLComments(statement)), " ")
+		code = strings.TrimSuffix(code, ";")
+		file.statements = append(file.statements, lintStatement{
+			code:    strings.TrimSpace(code),
+			ignored: ignoredRules(statement),
+		})
+	}
+
+	return file, nil
+}
+
+func ignoredRules(query string) map[string]bool {
+	ignored := make(map[string]bool)
+	for _, comment := range helpers.SQLComments(query) {
+		match := ignoreDirective.FindStringSubmatch(comment)
+		if match == nil {
+			continue
+		}
+		for _, rule := range strings.FieldsFunc(match[1], func(r rune) bool { return r == ',' || r == ' ' }) {
+			ignored[rule] = true
+		}
+	}
+	return ignored
+}
+
+func lintMigration(migration string, dialect string, up *lintFile, down *lintFile) []LintFinding {
+	var findings []LintFinding
+
+	report := func(file *lintFile, statement *lintStatement, rule string, format string, args ...any) {
+		if statement != nil && statement.ignored[rule] {
+			return
+		}
+		if statement == nil && file.ignored[rule] {
+			return
+		}
+		// Irreversible migrations are not expected to undo anything.
+		if up != nil && up.irreversible && (rule == RuleEmptyDown || rule == RuleDropWithoutDown) {
+			return
+		}
+		findings = append(findings, LintFinding{migration, file.name, rule, fmt.Sprintf(format, args...)})
+	}
+
+	postgres := dialect == "postgres"
+	sqlite := dialect == "sqlite" || dialect == "libsql"
+
+	if down == nil || len(down.statements) < 1 {
+		if down == nil {
+			down = &lintFile{name: "down.sql"}
+		}
+		report(down, nil, RuleEmptyDown, "down.sql is empty, the migration cannot be reverted")
+	}
+
+	downCreatedTables := make(map[string]bool)
+	downAddedColumns := make(map[string]bool)
+	for i := range down.statements {
+		statement := &down.statements[i]
+
+		if match := createTablePrefix.FindStringSubmatch(statement.code); match != nil {
+			downCreatedTables[normalizeIdentifier(match[1])] = true
+		}
+
+		if match := alterTablePrefix.FindStringSubmatch(statement.code); match != nil {
+			table := normalizeIdentifier(match[1])
+			for _, action := range splitAlterActions(match[2]) {
+				if add := addColumnAction.FindStringSubmatch(action); add != nil && !nonColumnKeywords[strings.ToLower(add[1])] {
+					downAddedColumns[table+"."+normalizeIdentifier(add[1])] = true
+				}
+			}
+		}
+
+		if match := dropObjectPrefix.FindStringSubmatch(statement.code); match != nil && match[2] == "" {
+			report(down, statement, RuleDownMissingIfExists, "%s should use IF EXISTS: %s", strings.ToUpper(match[1]), statement.code)
+		}
+	}
+
+	if up == nil {
+		return findings
+	}
+
+	upCreatedTables := make(map[string]bool)
+	upDroppedTables := make(map[string]bool)
+	upRenamedTables := make(map[string]bool)
+	for _, statement := range up.statements {
+		if match := createTablePrefix.FindStringSubmatch(statement.code); match != nil {
+			upCreatedTables[normalizeIdentifier(match[1])] = true
+		}
+		if match := alterTablePrefix.FindStringSubmatch(statement.code); match != nil {
+			for _, action := range splitAlterActions(match[2]) {
+				if rename := renameTableAction.FindStringSubmatch(action); rename != nil {
+					upRenamedTables[normalizeIdentifier(rename[1])] = true
+				}
+			}
+		}
+		if match := dropTablePrefix.FindStringSubmatch(statement.code); match != nil {
+			for _, table := range strings.Split(match[1], ",") {
+				upDroppedTables[normalizeIdentifier(table)] = true
+			}
+		}
+	}
+
+	for i := range up.statements {
+		statement := &up.statements[i]
+
+		if match := dropTablePrefix.FindStringSubmatch(statement.code); match != nil {
+			for _, table := range strings.Split(match[1], ",") {
+				table = normalizeIdentifier(table)
+				if !downCreatedTables[table] && !upCreatedTables[table] && !upRenamedTables[table] {
+					report(up, statement, RuleDropWithoutDown, "table %s is dropped but down.sql does not recreate it", table)
+				}
+			}
+		}
+
+		if match := alterTablePrefix.FindStringSubmatch(statement.code); match != nil {
+			table := normalizeIdentifier(match[1])
+			for _, action := range splitAlterActions(match[2]) {
+				if drop := dropColumnAction.FindStringSubmatch(action); drop != nil && !nonColumnKeywords[strings.ToLower(drop[1])] {
+					column := normalizeIdentifier(drop[1])
+					if !downAddedColumns[table+"."+column] {
+						report(up, statement, RuleDropWithoutDown, "column %s.%s is dropped but down.sql does not add it back", table, column)
+					}
+					if sqlite {
+						report(up, statement, RuleSQLiteTableRewrite, "dropping column %s rewrites table %s", column, table)
+					}
+				}
+
+				if add := addColumnAction.FindStringSubmatch(action); add != nil && postgres && !nonColumnKeywords[strings.ToLower(add[1])] {
+					if notNull.MatchString(add[2]) && !defaultClause.MatchString(add[2]) && !upCreatedTables[table] {
+						report(up, statement, RuleNotNullWithoutDefault, "column %s.%s is added as NOT NULL without a DEFAULT, this fails on tables with existing rows", table, normalizeIdentifier(add[1]))
+					}
+				}
+
+				if rename := renameTableAction.FindStringSubmatch(action); rename != nil && sqlite && upDroppedTables[normalizeIdentifier(rename[1])] {
+					report(up, statement, RuleSQLiteTableRewrite, "table %s is rebuilt by copying it into %s, this rewrites every row", normalizeIdentifier(rename[1]), table)
+				}
+			}
+		}
+
+		if match := createIndexPrefix.FindStringSubmatch(statement.code); match != nil && postgres && match[1] == "" {
+			table := normalizeIdentifier(match[2])
+			if !upCreatedTables[table] {
+				report(up, statement, RuleIndexNotConcurrent, "index on %s is created without CONCURRENTLY, this blocks writes while it builds on large tables", table)
+			}
+		}
+
+		if match := insertSelect.FindStringSubmatch(statement.code); match != nil && sqlite {
+			source := normalizeIdentifier(match[2])
+			if upDroppedTables[source] {
+				report(up, statement, RuleSQLiteTableRewrite, "rows of %s are copied into %s, this rewrites every row", source, normalizeIdentifier(match[1]))
+			}
+		}
+	}
+
+	return findings
+}
+
+// splitAlterActions splits the actions of an ALTER TABLE statement on commas
+// that are not inside parentheses.
+func splitAlterActions(actions string) []string {
+	var parts []string
+	depth := 0
+	start := 0
+	for i, r := range actions {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, strings.TrimSpace(actions[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, strings.TrimSpace(actions[start:]))
+}
+
+func normalizeIdentifier(identifier string) string {
+	identifier = strings.TrimSpace(identifier)
+	identifier = strings.Trim(identifier, "\"`[]")
+	identifier = strings.ReplaceAll(identifier, "\"", "")
+	identifier = strings.ReplaceAll(identifier, "`", "")
+	return strings.ToLower(identifier)
+}
@@ -1,28 +1,38 @@
 package miflo
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/gavsidhu/miflo/internal/database"
 	"github.com/gavsidhu/miflo/internal/helpers"
 )
 
-func ListPendingMigrations(db database.Database, cwd string) error {
+// pendingState is what ListPendingMigrations and CheckMigrations report on.
+type pendingState struct {
+	applied     []string
+	pending     []string
+	repeatables []RepeatableMigration
+	dirty       []string
+	outOfOrder  []string
+}
+
+func getPendingState(db database.Database, ctx context.Context, cwd string, o options) (pendingState, error) {
 	dirMigrations, err := helpers.GetDirMigrations(cwd)
 	if err != nil {
-		return err
+		return pendingState{}, err
 	}
 
-	appliedMigrationsRows, err := db.GetAppliedMigrations()
+	appliedMigrationsRows, err := db.GetAppliedMigrations(ctx)
 	if err != nil {
-		return fmt.Errorf("error getting applied migrations: %w", err)
+		return pendingState{}, fmt.Errorf("error getting applied migrations: %w", err)
 	}
 
 	defer appliedMigrationsRows.Close()
 
 	appliedMigrations, err := helpers.GetAppliedMigrationNames(appliedMigrationsRows)
 	if err != nil {
-		return fmt.Errorf("error getting applied migration names: %w", err)
+		return pendingState{}, fmt.Errorf("error getting applied migration names: %w", err)
 	}
 
 	var pendingMigrations []string
@@ -32,16 +42,62 @@ func ListPendingMigrations(db database.Database, cwd string) error {
 		}
 	}
 
-	if len(pendingMigrations) < 1 {
-		fmt.Println("No pending migrations")
-		return nil
+	pendingMigrations, _, err = resolveSquashedMigrations(cwd, appliedMigrations, pendingMigrations)
+	if err != nil {
+		return pendingState{}, err
+	}
+
+	pendingRepeatables, err := PendingRepeatableMigrations(ctx, db, cwd, o.vars)
+	if err != nil {
+		return pendingState{}, err
+	}
+
+	dirtyMigrations, err := db.GetDirtyMigrations(ctx)
+	if err != nil {
+		return pendingState{}, err
 	}
 
-	fmt.Println("Pending migrations:")
+	helpers.SortDirMigrations(pendingMigrations, true)
+
+	outOfOrder, _ := FindOutOfOrderMigrations(appliedMigrations, pendingMigrations)
+
+	return pendingState{
+		applied:     appliedMigrations,
+		pending:     pendingMigrations,
+		repeatables: pendingRepeatables,
+		dirty:       dirtyMigrations,
+		outOfOrder:  outOfOrder,
+	}, nil
+}
+
+// PendingMigrations is what ListPendingMigrations finds to apply.
+type PendingMigrations struct {
+	// Migrations are the pending migrations in the order they are applied.
+	Migrations []string
+	// OutOfOrder are the pending migrations older than the latest applied
+	// migration.
+	OutOfOrder []string
+	// Repeatables are the repeatable migrations that are new or changed.
+	Repeatables []RepeatableMigration
+	// Dirty are the migrations that failed halfway outside of a
+	// transaction.
+	Dirty []string
+}
+
+// ListPendingMigrations returns the migrations ApplyMigrations would apply
+// to db, without applying them.
+func ListPendingMigrations(db database.Database, ctx context.Context, cwd string, opts ...Option) (PendingMigrations, error) {
+	o := newOptions(opts)
 
-	for _, pending := range pendingMigrations {
-		fmt.Println(helpers.ColorYellow, pending, helpers.ColorReset)
+	state, err := getPendingState(db, ctx, cwd, o)
+	if err != nil {
+		return PendingMigrations{}, err
 	}
 
-	return nil
+	return PendingMigrations{
+		Migrations:  state.pending,
+		OutOfOrder:  state.outOfOrder,
+		Repeatables: state.repeatables,
+		Dirty:       state.dirty,
+	}, nil
 }
@@ -0,0 +1,88 @@
+package miflo
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/gavsidhu/miflo/internal/database"
+)
+
+// OutOfOrderPolicy controls what ApplyMigrations does with pending migrations
+// that are older than the latest applied migration.
+type OutOfOrderPolicy string
+
+const (
+	OutOfOrderError OutOfOrderPolicy = "error"
+	OutOfOrderWarn  OutOfOrderPolicy = "warn"
+	OutOfOrderAllow OutOfOrderPolicy = "allow"
+)
+
+func ParseOutOfOrderPolicy(policy string) (OutOfOrderPolicy, error) {
+	switch OutOfOrderPolicy(policy) {
+	case OutOfOrderError, OutOfOrderWarn, OutOfOrderAllow:
+		return OutOfOrderPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("invalid out-of-order policy %q, expected error, warn or allow", policy)
+	}
+}
+
+// Option configures ApplyMigrations, RevertMigrations and RunSeeds.
+type Option func(*options)
+
+type options struct {
+	outOfOrder OutOfOrderPolicy
+	hooks      Hooks
+	vars       map[string]string
+	dryRun     io.Writer
+	force      bool
+	timeouts   database.Timeouts
+	retry      database.Retry
+	onRun      []func(RunSummary)
+	logger     *slog.Logger
+	target     string
+	seedEnv    string
+	onlySeed   string
+	rerunSeeds bool
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		outOfOrder: OutOfOrderError,
+		logger:     slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func WithOutOfOrderPolicy(policy OutOfOrderPolicy) Option {
+	return func(o *options) {
+		o.outOfOrder = policy
+	}
+}
+
+// WithDryRun makes ApplyMigrations write the rendered SQL it would execute to
+// w instead of executing it.
+func WithDryRun(w io.Writer) Option {
+	return func(o *options) {
+		o.dryRun = w
+	}
+}
+
+// WithConnectRetry makes ApplyMigrationsToTargets retry connecting to targets
+// that are not reachable yet.
+func WithConnectRetry(retry database.Retry) Option {
+	return func(o *options) {
+		o.retry = retry
+	}
+}
+
+// WithLogger makes ApplyMigrations and RevertMigrations report their progress
+// and the statements they execute to logger instead of slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
@@ -0,0 +1,92 @@
+package miflo
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/helpers"
+)
+
+type OutOfOrderMigrationsError struct {
+	LatestApplied string
+	Migrations    []string
+}
+
+func (e *OutOfOrderMigrationsError) Error() string {
+	lines := []string{fmt.Sprintf("%d pending migration(s) are older than the latest applied migration %s:", len(e.Migrations), e.LatestApplied)}
+	for _, migration := range e.Migrations {
+		lines = append(lines, "  "+migration)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// FindOutOfOrderMigrations returns the pending migrations whose timestamp is
+// older than the newest applied migration, sorted in ascending order, along
+// with the newest applied migration.
+func FindOutOfOrderMigrations(applied []string, pending []string) ([]string, string) {
+	var latestApplied string
+	var latestTimestamp int64 = -1
+	for _, migration := range applied {
+		timestamp, _, err := helpers.ParseMigrationDir(migration)
+		if err != nil {
+			continue
+		}
+		if timestamp > latestTimestamp {
+			latestTimestamp = timestamp
+			latestApplied = migration
+		}
+	}
+
+	var outOfOrder []string
+	for _, migration := range pending {
+		timestamp, _, err := helpers.ParseMigrationDir(migration)
+		if err != nil {
+			continue
+		}
+		if timestamp < latestTimestamp {
+			outOfOrder = append(outOfOrder, migration)
+		}
+	}
+
+	helpers.SortDirMigrations(outOfOrder, true)
+
+	return outOfOrder, latestApplied
+}
+
+func getAppliedMigrations(ctx context.Context, db database.Database) ([]string, error) {
+	appliedMigrationsRows, err := db.GetAppliedMigrations(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting applied migrations: %w", err)
+	}
+
+	defer appliedMigrationsRows.Close()
+
+	appliedMigrations, err := helpers.GetAppliedMigrationNames(appliedMigrationsRows)
+	if err != nil {
+		return nil, fmt.Errorf("error getting applied migration names: %w", err)
+	}
+
+	return appliedMigrations, nil
+}
+
+func checkMigrationOrder(appliedMigrations []string, pendingMigrations []string, policy OutOfOrderPolicy, logger *slog.Logger) error {
+	if policy == OutOfOrderAllow {
+		return nil
+	}
+
+	outOfOrder, latestApplied := FindOutOfOrderMigrations(appliedMigrations, pendingMigrations)
+	if len(outOfOrder) < 1 {
+		return nil
+	}
+
+	orderErr := &OutOfOrderMigrationsError{LatestApplied: latestApplied, Migrations: outOfOrder}
+	if policy == OutOfOrderWarn {
+		logger.Warn(orderErr.Error(), "migrations", outOfOrder)
+		return nil
+	}
+
+	return orderErr
+}
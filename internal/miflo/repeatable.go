@@ -0,0 +1,100 @@
+package miflo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/helpers"
+)
+
+// RepeatableMigration is a SQL script in migrations/repeatable that is applied
+// again whenever its contents change.
+type RepeatableMigration struct {
+	// Name is the file name of the script, such as "active_users_view.sql".
+	Name     string
+	Checksum string
+	query    string
+}
+
+// GetRepeatableMigrations returns the repeatable migrations, rendered with
+// vars, sorted by name.
+func GetRepeatableMigrations(cwd string, vars map[string]string) ([]RepeatableMigration, error) {
+	repeatableDir := path.Join(cwd, "migrations", helpers.RepeatableDir)
+	entries, err := os.ReadDir(repeatableDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var repeatables []RepeatableMigration
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") || helpers.IsHidden(entry.Name()) {
+			continue
+		}
+
+		query, err := readSQLFile(path.Join(repeatableDir, entry.Name()), vars)
+		if err != nil {
+			return nil, err
+		}
+
+		repeatables = append(repeatables, RepeatableMigration{
+			Name:     entry.Name(),
+			Checksum: helpers.Checksum([]byte(query)),
+			query:    query,
+		})
+	}
+
+	sort.Slice(repeatables, func(i, j int) bool {
+		return repeatables[i].Name < repeatables[j].Name
+	})
+
+	return repeatables, nil
+}
+
+// PendingRepeatableMigrations returns the repeatable migrations that were
+// never applied or changed since they were last applied.
+func PendingRepeatableMigrations(ctx context.Context, db database.Database, cwd string, vars map[string]string) ([]RepeatableMigration, error) {
+	repeatables, err := GetRepeatableMigrations(cwd, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	checksums, err := db.GetRepeatableChecksums(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []RepeatableMigration
+	for _, repeatable := range repeatables {
+		if checksums[repeatable.Name] != repeatable.Checksum {
+			pending = append(pending, repeatable)
+		}
+	}
+
+	return pending, nil
+}
+
+func applyRepeatableMigrations(ctx context.Context, db database.Database, tx *sql.Tx, logger *slog.Logger, repeatables []RepeatableMigration) error {
+	for _, repeatable := range repeatables {
+		logger.DebugContext(ctx, "executing statement", "repeatable", repeatable.Name, "sql", strings.TrimSpace(repeatable.query))
+		if _, err := tx.ExecContext(ctx, repeatable.query); err != nil {
+			return fmt.Errorf("error executing repeatable migration %s: %w", repeatable.Name, err)
+		}
+
+		if err := db.RecordRepeatable(ctx, tx, repeatable.Name, repeatable.Checksum); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -3,51 +3,152 @@ package miflo
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/gavsidhu/miflo/internal/database"
 	"github.com/gavsidhu/miflo/internal/helpers"
 )
 
-func RevertMigrations(db database.Database, ctx context.Context, cwd string) error {
+func RevertMigrations(db database.Database, ctx context.Context, cwd string, opts ...Option) error {
+	o := newOptions(opts)
 
-	tx, err := db.BeginTx(ctx, nil)
+	summary := RunSummary{Direction: Down, Start: time.Now()}
+	err := revertMigrations(db, ctx, cwd, o, &summary)
+	return finishRun(db, ctx, cwd, o, summary, err)
+}
+
+func revertMigrations(db database.Database, ctx context.Context, cwd string, o options, summary *RunSummary) error {
+	release, err := db.Lock(ctx)
 	if err != nil {
-		return fmt.Errorf("error starting transaction: %w", err)
+		return err
 	}
-	defer tx.Rollback()
+	defer release()
 
-	batchNum, err := db.GetLastBatchNumber()
+	if err := checkDirty(ctx, db); err != nil {
+		return err
+	}
+
+	batchNum, err := db.GetLastBatchNumber(ctx)
 	if err != nil {
 		return fmt.Errorf("error getting last batch number: %w", err)
 	}
+	summary.Batch = batchNum
 
-	migrationsToRevert, err := db.GetMigrationsToRevert(batchNum)
+	migrationsToRevert, err := db.GetMigrationsToRevert(ctx, batchNum)
 	if err != nil {
 		return fmt.Errorf("error retrieving migrations to revert: %w", err)
 	}
 
 	if len(migrationsToRevert) < 1 {
-		fmt.Println("no migrations to revert")
+		o.logger.Info("no migrations to revert")
 		return nil
 	}
 
 	helpers.SortDirMigrations(migrationsToRevert, false)
 
-	for _, migration := range migrationsToRevert {
-		if err := db.RevertMigration(ctx, tx, migration, cwd); err != nil {
-			return err
-		}
+	steps, err := planRevert(cwd, migrationsToRevert, db, o)
+	if err != nil {
+		return err
+	}
+
+	batch, err := beginBatch(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer batch.rollback()
 
-		if err := db.DeleteMigration(ctx, tx, batchNum); err != nil {
-			return err
+	if err := runHook(ctx, batch.tx, cwd, beforeAllHook, o.hooks.BeforeAll, HookInfo{Direction: Down, Batch: batchNum}, o); err != nil {
+		return err
+	}
+
+	for i, step := range steps {
+		progress := fmt.Sprintf("[%d/%d] %s ...", i+1, len(steps), step.migration)
+		duration, dirty, err := revertStep(ctx, db, batch, cwd, step, batchNum, o)
+		if err != nil {
+			o.logger.Info(progress+" failed", "migration", step.migration, "error", err)
+			return interruptedError(ctx, Down, step, dirty, err)
+		}
+		if step.skip {
+			o.logger.Info(progress+" skipped", "migration", step.migration, "batch", batchNum, "skipped", true)
+		} else {
+			o.logger.Info(progress+" "+helpers.FormatDuration(duration), "migration", step.migration, "batch", batchNum, "duration_ms", duration.Milliseconds())
 		}
+
+		summary.Migrations = append(summary.Migrations, MigrationTiming{Name: step.migration, Duration: duration})
 	}
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("error committing transaction: %w", err)
+	if err := runHook(ctx, batch.tx, cwd, afterAllHook, o.hooks.AfterAll, HookInfo{Direction: Down, Batch: batchNum}, o); err != nil {
+		return err
 	}
 
-	fmt.Println("Migrations reverted successfully")
+	if err := batch.commit(); err != nil {
+		return err
+	}
+
+	duration := time.Since(summary.Start)
+	o.logger.Info("Migrations reverted successfully in "+helpers.FormatDuration(duration), "batch", batchNum, "migrations", len(summary.Migrations), "duration_ms", duration.Milliseconds())
 
 	return nil
 }
+
+// revertStep reverts one migration of the batch and returns how long its SQL
+// took to run, and whether it marked the migration dirty. A migration the
+// transaction cannot roll back is marked dirty right before its SQL runs,
+// and stays dirty until it is reverted, so a failure halfway is not forgotten.
+func revertStep(ctx context.Context, db database.Database, batch *batchTx, cwd string, step batchStep, batchNum int, o options) (time.Duration, bool, error) {
+	dirty := false
+
+	if step.unprotected {
+		if err := batch.split(); err != nil {
+			return 0, dirty, err
+		}
+	}
+
+	info := HookInfo{Migration: step.migration, Direction: Down, Batch: batchNum}
+	if err := runHook(ctx, batch.tx, cwd, beforeEachHook, o.hooks.BeforeEach, info, o); err != nil {
+		return 0, dirty, err
+	}
+
+	// As in applyStep, a failing before_each hook leaves nothing dirty.
+	if step.unprotected {
+		if err := batch.split(); err != nil {
+			return 0, dirty, err
+		}
+		if err := db.MarkDirty(ctx, step.migration, batchNum, string(Down)); err != nil {
+			return 0, dirty, err
+		}
+		dirty = true
+	}
+
+	var duration time.Duration
+	if !step.skip {
+		start := time.Now()
+		err := execStep(ctx, db, batch.tx, step, func(ctx context.Context) error {
+			if !step.withoutTx {
+				return db.RevertMigration(ctx, batch.tx, step.migration, step.query)
+			}
+			if err := execWithoutTransaction(ctx, db, o.logger, step.query); err != nil {
+				return fmt.Errorf("error reverting migration %s: %w", step.migration, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, dirty, err
+		}
+		duration = time.Since(start)
+	}
+
+	if err := db.DeleteMigration(ctx, batch.tx, step.migration); err != nil {
+		return 0, dirty, err
+	}
+
+	if err := runHook(ctx, batch.tx, cwd, afterEachHook, o.hooks.AfterEach, info, o); err != nil {
+		return 0, dirty, err
+	}
+
+	if step.unprotected {
+		return duration, dirty, batch.split()
+	}
+
+	return duration, dirty, nil
+}
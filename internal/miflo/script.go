@@ -0,0 +1,377 @@
+package miflo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/helpers"
+)
+
+// ScriptRange selects the migrations of a script written without a
+// database: those after From, which the database is expected to have
+// applied already, up to and including To. Both are the name of a migration
+// or its timestamp. An empty From starts with the first migration and an
+// empty To ends with the latest one.
+type ScriptRange struct {
+	From string
+	To   string
+}
+
+// WriteScript writes a SQL script for dialect that applies the migrations in
+// r and records them in the miflo tables, leaving the database in the state
+// ApplyMigrations would. Repeatable migrations are included when r ends with
+// the latest migration, as the state of the database is not known.
+func WriteScript(w io.Writer, cwd string, dialect database.Dialect, r ScriptRange, opts ...Option) error {
+	o := newOptions(opts)
+
+	if err := validateForScript(context.Background(), nil, cwd); err != nil {
+		return err
+	}
+
+	migrations, err := helpers.GetDirMigrations(cwd)
+	if err != nil {
+		return fmt.Errorf("error reading migrations directory: %w", err)
+	}
+	helpers.SortDirMigrations(migrations, true)
+
+	from := -1
+	if r.From != "" {
+		if from, err = findScriptMigration(migrations, r.From); err != nil {
+			return err
+		}
+	}
+
+	to := len(migrations) - 1
+	if r.To != "" {
+		if to, err = findScriptMigration(migrations, r.To); err != nil {
+			return err
+		}
+	}
+
+	if to < from {
+		return fmt.Errorf("%s comes before %s", migrations[to], migrations[from])
+	}
+
+	var repeatables []RepeatableMigration
+	if to == len(migrations)-1 {
+		if repeatables, err = GetRepeatableMigrations(cwd, o.vars); err != nil {
+			return err
+		}
+	}
+
+	description := "all migrations"
+	if r.From != "" {
+		description = "the migrations after " + migrations[from]
+	}
+	if r.To != "" {
+		description += " up to " + migrations[to]
+	}
+
+	return writeScript(w, cwd, dialect, description, nil, migrations[from+1:to+1], repeatables, o)
+}
+
+// WritePendingScript writes a SQL script that applies what ApplyMigrations
+// would apply to db, without changing db, and records it in the miflo
+// tables.
+func WritePendingScript(w io.Writer, db database.Database, ctx context.Context, cwd string, opts ...Option) error {
+	o := newOptions(opts)
+
+	if err := validateForScript(ctx, db, cwd); err != nil {
+		return err
+	}
+
+	if err := checkDirty(ctx, db); err != nil {
+		return err
+	}
+
+	pendingMigrations, err := db.GetUnappliedMigrations(ctx, cwd)
+	if err != nil {
+		return fmt.Errorf("error retrieving unapplied migrations: %w", err)
+	}
+
+	appliedMigrations, err := getAppliedMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	pendingMigrations, replacements, err := resolveSquashedMigrations(cwd, appliedMigrations, pendingMigrations)
+	if err != nil {
+		return err
+	}
+
+	if err := checkMigrationOrder(appliedMigrations, pendingMigrations, o.outOfOrder, o.logger); err != nil {
+		return err
+	}
+	helpers.SortDirMigrations(pendingMigrations, true)
+
+	pendingRepeatables, err := PendingRepeatableMigrations(ctx, db, cwd, o.vars)
+	if err != nil {
+		return err
+	}
+
+	return writeScript(w, cwd, db.Dialect(), "the migrations pending on the database", replacements, pendingMigrations, pendingRepeatables, o)
+}
+
+func validateForScript(ctx context.Context, db database.Database, cwd string) error {
+	problems, err := ValidateMigrations(db, ctx, cwd)
+	if err != nil {
+		return fmt.Errorf("error validating migrations: %w", err)
+	}
+
+	if errors := ValidationErrors(problems); len(errors) > 0 {
+		return &ValidationError{Problems: errors}
+	}
+
+	return nil
+}
+
+// findScriptMigration returns the index of the migration named or
+// timestamped version.
+func findScriptMigration(migrations []string, version string) (int, error) {
+	for i, migration := range migrations {
+		timestamp, _, _ := strings.Cut(migration, "_")
+		if migration == version || timestamp == version {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("migration %s not found", version)
+}
+
+// writeScript renders the statements applyMigrations would execute, along
+// with the bookkeeping it does through the database, as one script. The
+// batch is numbered by the script itself, as the database may have changed
+// by the time it runs.
+func writeScript(w io.Writer, cwd string, dialect database.Dialect, description string, replacements map[string][]string, migrations []string, repeatables []RepeatableMigration, o options) error {
+	s := &scriptWriter{w: w}
+
+	s.comment(fmt.Sprintf("Generated by miflo script for %s: %s.", dialect.Name(), description))
+	client := "sqlite3 -bail"
+	if dialect.Name() == "postgres" {
+		client = "psql -v ON_ERROR_STOP=1"
+	}
+	s.comment(fmt.Sprintf("Run it with a client that stops at the first error, such as %s.", client))
+	s.newline()
+
+	for _, query := range database.TablesSQL(dialect) {
+		s.outside(reindent(query))
+	}
+	s.newline()
+
+	if len(replacements) < 1 && len(migrations) < 1 && len(repeatables) < 1 {
+		s.comment("There are no pending migrations to apply.")
+		return s.err
+	}
+
+	timer := scriptTimerFor(dialect)
+	if timer.setup != "" {
+		s.outside(timer.setup)
+		s.newline()
+	}
+
+	hook := func(hookFile string) error {
+		query, err := hookSQL(cwd, hookFile, o.vars)
+		if err != nil || query == "" {
+			return err
+		}
+		s.comment(hookFile)
+		s.statement(strings.TrimSpace(query))
+		s.newline()
+		return nil
+	}
+
+	if err := hook(beforeAllHook); err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		query, err := migrationSQL(cwd, migration, Up, dialect.Name(), o.vars)
+		if err != nil {
+			return err
+		}
+
+		withoutTx := noTransaction(query)
+		unprotected := withoutTx || !dialect.TransactionalDDL()
+		name := quoteLiteral(migration)
+
+		if unprotected {
+			s.commit()
+		}
+
+		if err := hook(beforeEachHook); err != nil {
+			return err
+		}
+
+		// Like applyStep, a migration the transaction cannot roll back is
+		// marked dirty once its before_each hook is committed.
+		if unprotected {
+			s.commit()
+			s.comment(fmt.Sprintf("%s cannot be rolled back, it is marked dirty until it succeeds", migration))
+			s.outside(fmt.Sprintf("INSERT INTO miflo_migrations (name, batch, applied, dirty, direction) VALUES (%s, %s, FALSE, TRUE, 'up');", name, s.batch()))
+		}
+		s.comment(path.Join(migration, migrationFileName(cwd, migration, Up, dialect.Name())))
+		if withoutTx {
+			s.outside(timer.start)
+			s.outside(strings.TrimSpace(query))
+		} else {
+			s.statement(timer.start)
+			s.statement(strings.TrimSpace(query))
+		}
+
+		checksum := quoteLiteral(helpers.Checksum([]byte(query)))
+		if unprotected {
+			s.statement(fmt.Sprintf("UPDATE miflo_migrations SET applied = TRUE, dirty = FALSE, direction = NULL, checksum = %s, duration_ms = %s WHERE name = %s;", checksum, timer.elapsed, name))
+		} else {
+			s.statement(fmt.Sprintf("INSERT INTO miflo_migrations (name, batch, applied, checksum, duration_ms) VALUES (%s, %s, TRUE, %s, %s);", name, s.batch(), checksum, timer.elapsed))
+		}
+
+		if err := hook(afterEachHook); err != nil {
+			return err
+		}
+
+		if unprotected {
+			s.commit()
+		}
+		s.newline()
+	}
+
+	// ApplyMigrations numbers the batch before the squashed migrations
+	// are replaced, so they are replaced once the batch number is taken.
+	squashes := make([]string, 0, len(replacements))
+	for migration := range replacements {
+		squashes = append(squashes, migration)
+	}
+	helpers.SortDirMigrations(squashes, true)
+
+	for _, migration := range squashes {
+		names := quoteLiterals(replacements[migration])
+		s.comment(fmt.Sprintf("%s is recorded as applied in place of %d squashed migration(s)", migration, len(replacements[migration])))
+		s.statement(fmt.Sprintf("INSERT INTO miflo_migrations (name, batch, applied) VALUES (%s, 0, TRUE);", quoteLiteral(migration)))
+		s.statement(fmt.Sprintf("DELETE FROM miflo_migrations WHERE name IN (%s);", names))
+		s.newline()
+	}
+
+	for _, repeatable := range repeatables {
+		s.comment(path.Join(helpers.RepeatableDir, repeatable.Name))
+		s.statement(strings.TrimSpace(repeatable.query))
+		s.statement(fmt.Sprintf("DELETE FROM miflo_repeatable WHERE name = %s;", quoteLiteral(repeatable.Name)))
+		s.statement(fmt.Sprintf("INSERT INTO miflo_repeatable (name, checksum) VALUES (%s, %s);", quoteLiteral(repeatable.Name), quoteLiteral(repeatable.Checksum)))
+		s.newline()
+	}
+
+	if err := hook(afterAllHook); err != nil {
+		return err
+	}
+
+	s.commit()
+	return s.err
+}
+
+// scriptWriter writes the statements of a script. Statements that belong in
+// the batch transaction open it when needed, and those that must run
+// outside of it commit it first, so the script has no empty transactions.
+type scriptWriter struct {
+	w    io.Writer
+	inTx bool
+	// batched is set once a row of the batch the script applies has been
+	// written.
+	batched bool
+	err     error
+}
+
+func (s *scriptWriter) write(text string) {
+	if s.err == nil {
+		_, s.err = io.WriteString(s.w, text)
+	}
+}
+
+func (s *scriptWriter) newline() {
+	s.write("\n")
+}
+
+func (s *scriptWriter) comment(text string) {
+	s.write("-- " + text + "\n")
+}
+
+func (s *scriptWriter) statement(query string) {
+	if !s.inTx {
+		s.write("BEGIN;\n")
+		s.inTx = true
+	}
+	s.write(query + "\n")
+}
+
+func (s *scriptWriter) outside(query string) {
+	s.commit()
+	s.write(query + "\n")
+}
+
+func (s *scriptWriter) commit() {
+	if s.inTx {
+		s.write("COMMIT;\n")
+		s.inTx = false
+	}
+}
+
+// batch returns the expression for the batch number of the migrations the
+// script applies. The first row takes the number after the latest batch and
+// the next ones the batch of that row.
+func (s *scriptWriter) batch() string {
+	if !s.batched {
+		s.batched = true
+		return "(SELECT COALESCE(MAX(batch), 0) + 1 FROM miflo_migrations)"
+	}
+	return "(SELECT MAX(batch) FROM miflo_migrations)"
+}
+
+// scriptTimer measures how long each migration of a script takes, so its
+// duration_ms is recorded like ApplyMigrations records it.
+type scriptTimer struct {
+	setup   string
+	start   string
+	elapsed string
+}
+
+func scriptTimerFor(dialect database.Dialect) scriptTimer {
+	if dialect.Name() == "postgres" {
+		return scriptTimer{
+			start:   "SELECT set_config('miflo.started_at', CAST(clock_timestamp() AS TEXT), false);",
+			elapsed: "CAST(EXTRACT(EPOCH FROM clock_timestamp() - CAST(current_setting('miflo.started_at') AS TIMESTAMPTZ)) * 1000 AS INTEGER)",
+		}
+	}
+
+	return scriptTimer{
+		setup:   "CREATE TEMP TABLE IF NOT EXISTS miflo_script_timer (started_at REAL);",
+		start:   "DELETE FROM temp.miflo_script_timer;\nINSERT INTO temp.miflo_script_timer VALUES (julianday('now'));",
+		elapsed: "CAST((julianday('now') - (SELECT started_at FROM temp.miflo_script_timer)) * 86400000 AS INTEGER)",
+	}
+}
+
+// reindent indents the lines of a CREATE TABLE statement between its first
+// and last line by four spaces.
+func reindent(query string) string {
+	lines := strings.Split(strings.TrimSpace(query), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+		if i > 0 && i < len(lines)-1 {
+			lines[i] = "    " + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+func quoteLiterals(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = quoteLiteral(value)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,218 @@
+package miflo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/helpers"
+)
+
+// Seed is a SQL file in the seeds directory. Seeds directly in the seeds
+// directory run in every environment, seeds in seeds/<env> only run in that
+// environment.
+type Seed struct {
+	// Name identifies the seed, such as "1704662056_users" or
+	// "dev/1704662056_users".
+	Name      string
+	Env       string
+	Timestamp int64
+	path      string
+}
+
+// WithSeedEnv makes RunSeeds run the seeds of env in addition to the seeds
+// that run in every environment.
+func WithSeedEnv(env string) Option {
+	return func(o *options) {
+		o.seedEnv = env
+	}
+}
+
+// WithOnlySeed makes RunSeeds run the seed with this name, with or without
+// its timestamp and environment, instead of every seed.
+func WithOnlySeed(name string) Option {
+	return func(o *options) {
+		o.onlySeed = name
+	}
+}
+
+// WithRerun makes RunSeeds run seeds that have already run.
+func WithRerun() Option {
+	return func(o *options) {
+		o.rerunSeeds = true
+	}
+}
+
+func CreateSeed(seedName string, env string, cwd string, timestamp int64) (string, error) {
+	if !helpers.IsValidMigrationName(seedName) {
+		return "", errors.New("invalid seed name")
+	}
+
+	if env != "" && !helpers.IsValidMigrationName(env) {
+		return "", errors.New("invalid environment name")
+	}
+
+	seedsDir := path.Join(cwd, "seeds", env)
+	if err := os.MkdirAll(seedsDir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	pathName := path.Join(seedsDir, fmt.Sprintf("%d_%s.sql", timestamp, seedName))
+	file, err := os.OpenFile(pathName, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	return pathName, file.Close()
+}
+
+// GetSeeds returns the seeds that run in env, in order of their timestamps.
+func GetSeeds(cwd string, env string) ([]Seed, error) {
+	seeds, err := readSeedsDir(path.Join(cwd, "seeds"), "")
+	if err != nil {
+		return nil, err
+	}
+
+	if env != "" {
+		envSeeds, err := readSeedsDir(path.Join(cwd, "seeds", env), env)
+		if err != nil {
+			return nil, err
+		}
+		seeds = append(seeds, envSeeds...)
+	}
+
+	sort.SliceStable(seeds, func(i, j int) bool {
+		if seeds[i].Timestamp != seeds[j].Timestamp {
+			return seeds[i].Timestamp < seeds[j].Timestamp
+		}
+		return seeds[i].Name < seeds[j].Name
+	})
+
+	return seeds, nil
+}
+
+func readSeedsDir(dir string, env string) ([]Seed, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var seeds []Seed
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		timestamp, _, err := helpers.ParseMigrationDir(name)
+		if err != nil {
+			return nil, fmt.Errorf("seed %s: %w", entry.Name(), err)
+		}
+
+		if env != "" {
+			name = env + "/" + name
+		}
+
+		seeds = append(seeds, Seed{Name: name, Env: env, Timestamp: timestamp, path: path.Join(dir, entry.Name())})
+	}
+
+	return seeds, nil
+}
+
+// matches reports whether name refers to the seed, with or without its
+// environment and timestamp.
+func (s Seed) matches(name string) bool {
+	if s.Name == name {
+		return true
+	}
+
+	base := strings.TrimPrefix(s.Name, s.Env+"/")
+	_, shortName, _ := helpers.ParseMigrationDir(base)
+	return name == base || name == shortName || (s.Env != "" && name == s.Env+"/"+shortName)
+}
+
+// RunSeeds runs the seeds that have not run yet in a single transaction and
+// returns the names of the seeds it ran.
+func RunSeeds(db database.Database, ctx context.Context, cwd string, opts ...Option) ([]string, error) {
+	o := newOptions(opts)
+
+	seeds, err := GetSeeds(cwd, o.seedEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.onlySeed != "" {
+		var selected []Seed
+		for _, seed := range seeds {
+			if seed.matches(o.onlySeed) {
+				selected = append(selected, seed)
+			}
+		}
+
+		switch {
+		case len(selected) < 1:
+			return nil, fmt.Errorf("seed %s not found", o.onlySeed)
+		case len(selected) > 1:
+			return nil, fmt.Errorf("seed name %s is ambiguous, use the full name", o.onlySeed)
+		}
+		seeds = selected
+	}
+
+	release, err := db.Lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if err := checkDirty(ctx, db); err != nil {
+		return nil, err
+	}
+
+	appliedSeeds, err := db.GetAppliedSeeds(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var ran []string
+	for _, seed := range seeds {
+		if !o.rerunSeeds && helpers.Contains(appliedSeeds, seed.Name) {
+			continue
+		}
+
+		query, err := readSQLFile(seed.path, o.vars)
+		if err != nil {
+			return nil, err
+		}
+
+		o.logger.DebugContext(ctx, "executing statement", "seed", seed.Name, "sql", strings.TrimSpace(query))
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return nil, fmt.Errorf("error executing seed file %s: %w", seed.path, err)
+		}
+
+		if err := db.RecordSeed(ctx, tx, seed.Name); err != nil {
+			return nil, err
+		}
+
+		ran = append(ran, seed.Name)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return ran, nil
+}
@@ -0,0 +1,268 @@
+package miflo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/helpers"
+)
+
+const squashedSuffix = "_squashed"
+
+// MigrationsToSquash returns the migrations, in ascending order, that
+// SquashMigrations would replace for version. version is either a timestamp
+// or a full migration name.
+func MigrationsToSquash(cwd string, version string) ([]string, int64, error) {
+	prefix, _, _ := strings.Cut(version, "_")
+	toTimestamp, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid version %q, expected a migration timestamp", version)
+	}
+
+	dirMigrations, err := helpers.GetDirMigrations(cwd)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	helpers.SortDirMigrations(dirMigrations, true)
+
+	var migrations []string
+	for _, migration := range dirMigrations {
+		timestamp, _, err := helpers.ParseMigrationDir(migration)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %w", migration, err)
+		}
+		if timestamp <= toTimestamp {
+			migrations = append(migrations, migration)
+		}
+	}
+
+	if len(migrations) < 1 {
+		return nil, 0, fmt.Errorf("no migrations found up to version %d", toTimestamp)
+	}
+
+	return migrations, toTimestamp, nil
+}
+
+// SquashMigrations replaces every migration up to and including version with a
+// single baseline migration. The baseline is generated by replaying the
+// migrations into a scratch database of the same dialect as scratchURL and
+// dumping its schema, so data inserted by the migrations is not carried over.
+// The baseline is irreversible and has no down.sql, as reverting it would drop
+// everything the squashed migrations created. The original migration
+// directories are moved to migrations_archive. It returns the name of the new
+// migration.
+func SquashMigrations(ctx context.Context, scratchURL string, cwd string, version string, opts ...Option) (string, error) {
+	o := newOptions(opts)
+
+	migrations, toTimestamp, err := MigrationsToSquash(cwd, version)
+	if err != nil {
+		return "", err
+	}
+
+	var replaced []string
+	for _, migration := range migrations {
+		nested, err := squashedMigrations(cwd, migration)
+		if err != nil {
+			return "", err
+		}
+		replaced = append(replaced, nested...)
+		replaced = append(replaced, migration)
+	}
+
+	scratch, cleanup, err := database.NewScratchDatabase(scratchURL)
+	if err != nil {
+		return "", fmt.Errorf("error setting up scratch database: %w", err)
+	}
+
+	defer cleanup()
+
+	dialect, err := database.DialectName(scratchURL)
+	if err != nil {
+		return "", err
+	}
+
+	// Repeatable migrations stay in place and are not part of the baseline.
+	if err := replayMigrations(ctx, scratch, cwd, migrations, nil, dialect, o); err != nil {
+		return "", err
+	}
+
+	schema, err := scratch.Introspect(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error reading scratch database schema: %w", err)
+	}
+
+	var up strings.Builder
+	fmt.Fprintf(&up, "-- miflo:%s baseline generated by miflo squash, restore the originals from migrations_archive to go back further\n", irreversibleDirective)
+	for _, migration := range replaced {
+		fmt.Fprintf(&up, "-- miflo:squashes %s\n", migration)
+	}
+	fmt.Fprintf(&up, "-- Baseline generated by miflo squash from %d migration(s).\n\n", len(migrations))
+	for _, statement := range schema.CreateStatements() {
+		fmt.Fprintf(&up, "%s;\n\n", strings.TrimSuffix(statement, ";"))
+	}
+
+	archiveDir := path.Join(cwd, "migrations_archive")
+	for _, migration := range migrations {
+		if _, err := os.Stat(path.Join(archiveDir, migration)); err == nil {
+			return "", fmt.Errorf("%s is already archived in %s", migration, archiveDir)
+		}
+	}
+
+	if err := os.MkdirAll(archiveDir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	restore, err := archiveMigrations(cwd, archiveDir, migrations)
+	if err != nil {
+		return "", err
+	}
+
+	squashed := fmt.Sprintf("%d%s", toTimestamp, squashedSuffix)
+	pathName := path.Join(cwd, "migrations", squashed)
+	if err := os.Mkdir(pathName, os.ModePerm); err != nil {
+		return "", errors.Join(err, restore())
+	}
+
+	if err := os.WriteFile(path.Join(pathName, "up.sql"), []byte(up.String()), 0644); err != nil {
+		return "", errors.Join(err, os.RemoveAll(pathName), restore())
+	}
+
+	return squashed, nil
+}
+
+// archiveMigrations moves the migration directories to archiveDir. If one
+// cannot be moved, those already moved are put back so the migrations
+// directory is left as it was. The returned function puts them all back.
+func archiveMigrations(cwd string, archiveDir string, migrations []string) (func() error, error) {
+	var archived []string
+	restore := func() error {
+		var errs []error
+		for _, migration := range archived {
+			if err := os.Rename(path.Join(archiveDir, migration), path.Join(cwd, "migrations", migration)); err != nil {
+				errs = append(errs, fmt.Errorf("error restoring %s from %s: %w", migration, archiveDir, err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+
+	for _, migration := range migrations {
+		if err := os.Rename(path.Join(cwd, "migrations", migration), path.Join(archiveDir, migration)); err != nil {
+			return nil, errors.Join(fmt.Errorf("error archiving %s: %w", migration, err), restore())
+		}
+		archived = append(archived, migration)
+	}
+
+	return restore, nil
+}
+
+// replayMigrations applies migrations to db, using the variants for dialect,
+// followed by repeatables.
+func replayMigrations(ctx context.Context, db database.Database, cwd string, migrations []string, repeatables []RepeatableMigration, dialect string, o options) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, migration := range migrations {
+		query, err := migrationSQL(cwd, migration, Up, dialect, o.vars)
+		if err != nil {
+			return err
+		}
+
+		if err := db.ApplyMigration(ctx, tx, migration, query); err != nil {
+			return err
+		}
+	}
+
+	if err := applyRepeatableMigrations(ctx, db, tx, o.logger, repeatables); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// squashedMigrations returns the migrations a squashed migration replaces, as
+// listed by the "-- miflo:squashes" directives at the top of its up.sql.
+func squashedMigrations(cwd string, migration string) ([]string, error) {
+	sqlBytes, err := os.ReadFile(path.Join(cwd, "migrations", migration, "up.sql"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var replaced []string
+	for _, directive := range helpers.ParseHeaderDirectives(string(sqlBytes)) {
+		if directive.Name == "squashes" && directive.Value != "" {
+			replaced = append(replaced, directive.Value)
+		}
+	}
+
+	return replaced, nil
+}
+
+// resolveSquashedMigrations removes pending squashed migrations whose
+// originals were already applied. It returns the remaining pending
+// migrations and, for each squashed migration that is considered applied,
+// the applied originals it replaces.
+func resolveSquashedMigrations(cwd string, applied []string, pending []string) ([]string, map[string][]string, error) {
+	var remaining []string
+	replacements := make(map[string][]string)
+
+	for _, migration := range pending {
+		replaced, err := squashedMigrations(cwd, migration)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var appliedReplaced, missing []string
+		for _, original := range replaced {
+			if helpers.Contains(applied, original) {
+				appliedReplaced = append(appliedReplaced, original)
+			} else if !coveredBySquash(original, applied, replaced) {
+				missing = append(missing, original)
+			}
+		}
+
+		switch {
+		case len(appliedReplaced) < 1:
+			remaining = append(remaining, migration)
+		case len(missing) > 0:
+			return nil, nil, fmt.Errorf("%s replaces migrations that were only partly applied, restore %s from migrations_archive and apply them before applying the squashed migration", migration, strings.Join(missing, ", "))
+		default:
+			replacements[migration] = appliedReplaced
+		}
+	}
+
+	return remaining, replacements, nil
+}
+
+// coveredBySquash reports whether an earlier squash that is already applied
+// includes migration. A squash covers every migration up to its timestamp.
+func coveredBySquash(migration string, applied []string, replaced []string) bool {
+	timestamp, _, err := helpers.ParseMigrationDir(migration)
+	if err != nil {
+		return false
+	}
+
+	for _, original := range replaced {
+		if !strings.HasSuffix(original, squashedSuffix) || !helpers.Contains(applied, original) {
+			continue
+		}
+		squashTimestamp, _, err := helpers.ParseMigrationDir(original)
+		if err == nil && squashTimestamp >= timestamp {
+			return true
+		}
+	}
+
+	return false
+}
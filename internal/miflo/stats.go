@@ -0,0 +1,72 @@
+package miflo
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/gavsidhu/miflo/internal/database"
+)
+
+// BatchStats is how long the migrations of one batch took to run.
+type BatchStats struct {
+	Batch      int
+	Migrations int
+	Duration   time.Duration
+}
+
+// MigrationStats summarizes the durations recorded for the applied
+// migrations. Migrations applied before durations were recorded are left
+// out.
+type MigrationStats struct {
+	Timed int
+	Total time.Duration
+	// Slowest lists the slowest migrations, slowest first.
+	Slowest []database.MigrationDuration
+	// Batches lists the most recent batches, most recent first.
+	Batches []BatchStats
+}
+
+// GetMigrationStats returns the stats of the applied migrations, with at most
+// limit migrations in Slowest and limit batches in Batches.
+func GetMigrationStats(db database.Database, ctx context.Context, limit int) (MigrationStats, error) {
+	durations, err := db.GetMigrationDurations(ctx)
+	if err != nil {
+		return MigrationStats{}, err
+	}
+
+	stats := MigrationStats{Timed: len(durations)}
+	batches := make(map[int]*BatchStats)
+	for _, migration := range durations {
+		stats.Total += migration.Duration
+
+		batch, ok := batches[migration.Batch]
+		if !ok {
+			batch = &BatchStats{Batch: migration.Batch}
+			batches[migration.Batch] = batch
+		}
+		batch.Migrations++
+		batch.Duration += migration.Duration
+	}
+
+	sort.SliceStable(durations, func(i, j int) bool {
+		if durations[i].Duration != durations[j].Duration {
+			return durations[i].Duration > durations[j].Duration
+		}
+		return durations[i].Name < durations[j].Name
+	})
+	if len(durations) > limit {
+		durations = durations[:limit]
+	}
+	stats.Slowest = durations
+
+	for _, batch := range batches {
+		stats.Batches = append(stats.Batches, *batch)
+	}
+	sort.Slice(stats.Batches, func(i, j int) bool { return stats.Batches[i].Batch > stats.Batches[j].Batch })
+	if len(stats.Batches) > limit {
+		stats.Batches = stats.Batches[:limit]
+	}
+
+	return stats, nil
+}
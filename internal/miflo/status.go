@@ -0,0 +1,137 @@
+package miflo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/helpers"
+)
+
+// MigrationStatus describes how far a database, or one of its schemas, is
+// from the migrations directory.
+type MigrationStatus struct {
+	// Schema is empty for the status of the database itself.
+	Schema  string
+	Applied int
+	// Latest is the most recent applied migration.
+	Latest  string
+	Pending []string
+	// Dirty lists the migrations that failed halfway outside of a
+	// transaction.
+	Dirty []string
+}
+
+// Behind reports whether the database has pending migrations.
+func (s MigrationStatus) Behind() bool {
+	return len(s.Pending) > 0
+}
+
+// GetStatus returns the status of the database.
+func GetStatus(db database.Database, ctx context.Context, cwd string) (MigrationStatus, error) {
+	rows, err := db.GetAppliedMigrations(ctx)
+	if err != nil {
+		return MigrationStatus{}, fmt.Errorf("error getting applied migrations: %w", err)
+	}
+
+	defer rows.Close()
+
+	applied, err := helpers.GetAppliedMigrationNames(rows)
+	if err != nil {
+		return MigrationStatus{}, fmt.Errorf("error getting applied migration names: %w", err)
+	}
+
+	status, err := newMigrationStatus(cwd, "", applied)
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+
+	status.Dirty, err = db.GetDirtyMigrations(ctx)
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+
+	return status, nil
+}
+
+// GetSchemaStatuses returns the status of every PostgreSQL schema matching
+// the LIKE pattern, or of every schema with a miflo_migrations table when
+// pattern is empty.
+func GetSchemaStatuses(ctx context.Context, db database.Database, cwd string, pattern string) ([]MigrationStatus, error) {
+	schemas, err := database.ListSchemas(ctx, db, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, schema := range schemas {
+		applied, err := database.SchemaAppliedMigrations(ctx, db, schema)
+		if err != nil {
+			return nil, err
+		}
+
+		status, err := newMigrationStatus(cwd, schema, applied)
+		if err != nil {
+			return nil, err
+		}
+
+		status.Dirty, err = database.SchemaDirtyMigrations(ctx, db, schema)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// SchemaTargets returns a connection URL for every PostgreSQL schema matching
+// the LIKE pattern, each using the schema as its search_path so it is
+// migrated with its own miflo_migrations table.
+func SchemaTargets(ctx context.Context, db database.Database, databaseURL string, pattern string) ([]string, error) {
+	schemas, err := database.ListSchemas(ctx, db, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []string
+	for _, schema := range schemas {
+		target, err := database.WithSearchPath(databaseURL, schema)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+
+	return targets, nil
+}
+
+func newMigrationStatus(cwd string, schema string, applied []string) (MigrationStatus, error) {
+	dirMigrations, err := helpers.GetDirMigrations(cwd)
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+
+	var pending []string
+	for _, migration := range dirMigrations {
+		if !helpers.Contains(applied, migration) {
+			pending = append(pending, migration)
+		}
+	}
+
+	pending, _, err = resolveSquashedMigrations(cwd, applied, pending)
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+
+	helpers.SortDirMigrations(pending, true)
+
+	status := MigrationStatus{Schema: schema, Applied: len(applied), Pending: pending}
+	if len(applied) > 0 {
+		latest := append([]string(nil), applied...)
+		helpers.SortDirMigrations(latest, false)
+		status.Latest = latest[0]
+	}
+
+	return status, nil
+}
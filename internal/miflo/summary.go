@@ -0,0 +1,74 @@
+package miflo
+
+import (
+	"context"
+	"time"
+
+	"github.com/gavsidhu/miflo/internal/database"
+)
+
+// MigrationTiming is how long the SQL of one migration took to run.
+type MigrationTiming struct {
+	Name     string
+	Duration time.Duration
+}
+
+// RunSummary describes a run of ApplyMigrations or RevertMigrations.
+type RunSummary struct {
+	Direction Direction
+	// Batch is the batch the migrations were applied in or reverted from. It
+	// is 0 when the run ended before a batch was chosen.
+	Batch int
+	// Migrations lists the migrations that ran, in order. When Err is set
+	// the ones protected by the batch transaction were rolled back.
+	Migrations []MigrationTiming
+	Start      time.Time
+	Duration   time.Duration
+	Err        error
+	// Target is the database URL with its credentials redacted when the run
+	// is part of ApplyMigrationsToTargets, and empty otherwise.
+	Target string
+	// Status is the status of the database after the run and LastBatch its
+	// latest batch. Status is nil when they could not be read, such as when
+	// the database could not be reached.
+	Status    *MigrationStatus
+	LastBatch int
+}
+
+// WithRunSummary makes ApplyMigrations and RevertMigrations call fn with a
+// summary when they return, whether they succeeded or not. It is not called
+// for dry runs. ApplyMigrationsToTargets calls it once per target, from
+// several goroutines at a time, including for targets it could not connect
+// to. Every function given with WithRunSummary is called, in order.
+func WithRunSummary(fn func(RunSummary)) Option {
+	return func(o *options) {
+		o.onRun = append(o.onRun, fn)
+	}
+}
+
+// finishRun completes summary with the outcome of the run and the status of
+// db, and reports it. db is nil when the run failed before connecting.
+func finishRun(db database.Database, ctx context.Context, cwd string, o options, summary RunSummary, err error) error {
+	summary.Duration = time.Since(summary.Start)
+	summary.Err = err
+	summary.Target = o.target
+
+	if len(o.onRun) == 0 || o.dryRun != nil {
+		return err
+	}
+
+	if db != nil {
+		status, statusErr := GetStatus(db, ctx, cwd)
+		lastBatch, batchErr := db.GetLastBatchNumber(ctx)
+		if statusErr == nil && batchErr == nil {
+			summary.Status = &status
+			summary.LastBatch = lastBatch
+		}
+	}
+
+	for _, fn := range o.onRun {
+		fn(summary)
+	}
+
+	return err
+}
@@ -0,0 +1,168 @@
+package miflo
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/helpers"
+)
+
+// TargetResult is the outcome of applying migrations to one target database.
+type TargetResult struct {
+	// Target is the database URL with its credentials redacted.
+	Target   string
+	Err      error
+	Duration time.Duration
+	url      string
+}
+
+// ParseTargets reads database URLs, one per line or separated by commas.
+// Blank lines and lines starting with # are ignored.
+func ParseTargets(r io.Reader) ([]string, error) {
+	var targets []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, target := range strings.Split(line, ",") {
+			if target = strings.TrimSpace(target); target != "" {
+				targets = append(targets, target)
+			}
+		}
+	}
+
+	return targets, scanner.Err()
+}
+
+// QueryTargets returns the database URLs in the first column of the rows
+// returned by query.
+func QueryTargets(ctx context.Context, db database.Database, query string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("error querying targets: %w", err)
+	}
+
+	defer rows.Close()
+
+	return helpers.GetAppliedMigrationNames(rows)
+}
+
+// ApplyMigrationsToTargets applies the pending migrations to every target
+// database, running at most parallel targets at a time. Each target is
+// migrated in its own transaction and holds its own lock, so a failing target
+// does not affect the others. Results are returned in the order of targets.
+func ApplyMigrationsToTargets(ctx context.Context, targets []string, cwd string, parallel int, opts ...Option) []TargetResult {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	results := make([]TargetResult, len(targets))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < parallel && i < len(targets); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = applyMigrationsToTarget(ctx, targets[i], cwd, opts)
+			}
+		}()
+	}
+
+	for i := range targets {
+		jobs <- i
+	}
+	close(jobs)
+
+	wg.Wait()
+
+	return results
+}
+
+func applyMigrationsToTarget(ctx context.Context, target string, cwd string, opts []Option) TargetResult {
+	result := TargetResult{Target: helpers.RedactURL(target), url: target}
+	start := time.Now()
+
+	logger := newOptions(opts).logger.With("target", result.Target)
+	targetOpts := append(append([]Option(nil), opts...), WithLogger(logger), func(o *options) {
+		o.target = result.Target
+	})
+	o := newOptions(targetOpts)
+
+	db, err := database.Connect(ctx, target, o.retry, nil, database.WithLogger(logger))
+	if err != nil {
+		result.Err = finishRun(nil, ctx, cwd, o, RunSummary{Direction: Up, Start: start}, fmt.Errorf("error setting up database: %w", err))
+		result.Duration = time.Since(start)
+		return result
+	}
+
+	defer db.Close()
+
+	result.Err = ApplyMigrations(db, ctx, cwd, targetOpts...)
+	result.Duration = time.Since(start)
+
+	return result
+}
+
+type targetState struct {
+	// Failed holds the checksums of the URLs of the targets that failed, so
+	// the state file does not contain credentials.
+	Failed []string `json:"failed"`
+}
+
+// FailedTargets returns the targets that failed in the run recorded in
+// stateFile.
+func FailedTargets(stateFile string, targets []string) ([]string, error) {
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no previous run recorded in %s", stateFile)
+		}
+		return nil, err
+	}
+
+	var state targetState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", stateFile, err)
+	}
+
+	var failed []string
+	for _, target := range targets {
+		if helpers.Contains(state.Failed, helpers.Checksum([]byte(target))) {
+			failed = append(failed, target)
+		}
+	}
+
+	return failed, nil
+}
+
+// SaveTargetState records the targets that failed in stateFile so they can be
+// retried with FailedTargets.
+func SaveTargetState(stateFile string, results []TargetResult) error {
+	state := targetState{Failed: []string{}}
+	for _, result := range results {
+		if result.Err != nil {
+			state.Failed = append(state.Failed, helpers.Checksum([]byte(result.url)))
+		}
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(stateFile, append(data, '\n'), 0600)
+}
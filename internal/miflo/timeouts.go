@@ -0,0 +1,100 @@
+package miflo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/helpers"
+)
+
+// The statement-timeout and lock-timeout directives at the top of a migration
+// file override the timeouts of the run for that file, for example:
+//
+//	-- miflo:lock-timeout 2s
+const (
+	statementTimeoutDirective = "statement-timeout"
+	lockTimeoutDirective      = "lock-timeout"
+)
+
+// WithStatementTimeout limits how long each migration may run.
+func WithStatementTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeouts.Statement = timeout
+	}
+}
+
+// WithLockTimeout limits how long each migration may wait for a lock.
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeouts.Lock = timeout
+	}
+}
+
+// migrationTimeouts returns the timeouts query runs with, the timeouts of the
+// run overridden by the timeout directives of query.
+func migrationTimeouts(query string, timeouts database.Timeouts) (database.Timeouts, error) {
+	for _, directive := range helpers.ParseHeaderDirectives(query) {
+		var timeout *time.Duration
+		switch directive.Name {
+		case statementTimeoutDirective:
+			timeout = &timeouts.Statement
+		case lockTimeoutDirective:
+			timeout = &timeouts.Lock
+		default:
+			continue
+		}
+
+		d, err := time.ParseDuration(directive.Value)
+		if err != nil || d < 0 {
+			return database.Timeouts{}, fmt.Errorf("invalid %s %q, expected a duration such as 30s", directive.Name, directive.Value)
+		}
+		*timeout = d
+	}
+
+	return timeouts, nil
+}
+
+// execStep calls exec, which runs the SQL of step, with the timeouts of step
+// in effect. The timeouts are reset afterwards so they do not apply to the
+// rest of the transaction.
+func execStep(ctx context.Context, db database.Database, tx *sql.Tx, step batchStep, exec func(ctx context.Context) error) error {
+	if step.timeouts == (database.Timeouts{}) {
+		return exec(ctx)
+	}
+
+	var stepCtx context.Context
+	var cancel context.CancelFunc
+	if step.withoutTx {
+		stepCtx, cancel = database.DeadlineContext(ctx, step.timeouts)
+	} else {
+		var err error
+		stepCtx, cancel, err = db.Dialect().SetTimeouts(ctx, tx, step.timeouts)
+		if err != nil {
+			return err
+		}
+	}
+	defer cancel()
+
+	if err := exec(stepCtx); err != nil {
+		if ctx.Err() == nil && errors.Is(stepCtx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("migration %s timed out: %w", step.migration, err)
+		}
+		return err
+	}
+
+	if step.withoutTx {
+		return nil
+	}
+
+	_, reset, err := db.Dialect().SetTimeouts(ctx, tx, database.Timeouts{})
+	if err != nil {
+		return err
+	}
+	reset()
+
+	return nil
+}
@@ -3,51 +3,258 @@ package miflo
 import (
 	"context"
 	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/gavsidhu/miflo/internal/database"
 	"github.com/gavsidhu/miflo/internal/helpers"
 )
 
-func ApplyMigrations(db database.Database, ctx context.Context, cwd string) error {
+func ApplyMigrations(db database.Database, ctx context.Context, cwd string, opts ...Option) error {
+	o := newOptions(opts)
 
-	tx, err := db.BeginTx(ctx, nil)
+	summary := RunSummary{Direction: Up, Start: time.Now()}
+	err := applyMigrations(db, ctx, cwd, o, &summary)
+	return finishRun(db, ctx, cwd, o, summary, err)
+}
+
+func applyMigrations(db database.Database, ctx context.Context, cwd string, o options, summary *RunSummary) error {
+	problems, err := ValidateMigrations(db, ctx, cwd)
+	if err != nil {
+		return fmt.Errorf("error validating migrations: %w", err)
+	}
+
+	if errors := ValidationErrors(problems); len(errors) > 0 {
+		return &ValidationError{Problems: errors}
+	}
+
+	release, err := db.Lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err := checkDirty(ctx, db); err != nil {
+		return err
+	}
+
+	batch, err := beginBatch(ctx, db)
 	if err != nil {
-		return fmt.Errorf("error starting transaction: %w", err)
+		return err
 	}
-	defer tx.Rollback()
+	defer batch.rollback()
 
-	batchNum, err := db.GetNextBatchNumber()
+	batchNum, err := db.GetNextBatchNumber(ctx)
 	if err != nil {
 		return fmt.Errorf("error getting next batch number: %w", err)
 	}
+	summary.Batch = batchNum
 
-	pendingMigrations, err := db.GetUnappliedMigrations(cwd)
+	pendingMigrations, err := db.GetUnappliedMigrations(ctx, cwd)
 	if err != nil {
 		return fmt.Errorf("error retrieving unapplied migrations: %w", err)
 	}
 
-	if len(pendingMigrations) < 1 {
-		fmt.Println("no pending migrations to apply")
+	appliedMigrations, err := getAppliedMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	pendingMigrations, replacements, err := resolveSquashedMigrations(cwd, appliedMigrations, pendingMigrations)
+	if err != nil {
+		return err
+	}
+
+	squashes := make([]string, 0, len(replacements))
+	for migration := range replacements {
+		squashes = append(squashes, migration)
+	}
+	helpers.SortDirMigrations(squashes, true)
+
+	for _, migration := range squashes {
+		if o.dryRun != nil {
+			fmt.Fprintf(o.dryRun, "-- %s would be recorded as applied in place of %d squashed migration(s)\n\n", migration, len(replacements[migration]))
+			continue
+		}
+		if err := db.ReplaceMigrations(ctx, batch.tx, replacements[migration], migration); err != nil {
+			return err
+		}
+		o.logger.Info(fmt.Sprintf("Recorded %s as applied in place of %d squashed migration(s)", migration, len(replacements[migration])), "migration", migration, "replaces", replacements[migration])
+	}
+
+	pendingRepeatables, err := PendingRepeatableMigrations(ctx, db, cwd, o.vars)
+	if err != nil {
+		return err
+	}
+
+	if len(pendingMigrations) < 1 && len(pendingRepeatables) < 1 {
+		if err := batch.commit(); err != nil {
+			return err
+		}
+		o.logger.Info("no pending migrations to apply")
 		return nil
 	}
 
+	if err := checkMigrationOrder(appliedMigrations, pendingMigrations, o.outOfOrder, o.logger); err != nil {
+		return err
+	}
+
 	helpers.SortDirMigrations(pendingMigrations, true)
 
-	for _, migration := range pendingMigrations {
-		if err := db.ApplyMigration(ctx, tx, migration, cwd); err != nil {
+	if o.dryRun != nil {
+		return printDryRun(o.dryRun, cwd, pendingMigrations, pendingRepeatables, db.Dialect().Name(), o.vars)
+	}
+
+	if err := runHook(ctx, batch.tx, cwd, beforeAllHook, o.hooks.BeforeAll, HookInfo{Direction: Up, Batch: batchNum}, o); err != nil {
+		return err
+	}
+
+	for i, migration := range pendingMigrations {
+		query, err := migrationSQL(cwd, migration, Up, db.Dialect().Name(), o.vars)
+		if err != nil {
 			return err
 		}
 
-		if err := db.RecordMigration(ctx, tx, migration, batchNum); err != nil {
+		step, err := newBatchStep(db, migration, query, o)
+		if err != nil {
 			return err
 		}
+
+		progress := fmt.Sprintf("[%d/%d] %s ...", i+1, len(pendingMigrations), migration)
+		duration, dirty, err := applyStep(ctx, db, batch, cwd, step, batchNum, o)
+		if err != nil {
+			o.logger.Info(progress+" failed", "migration", migration, "error", err)
+			return interruptedError(ctx, Up, step, dirty, err)
+		}
+		o.logger.Info(progress+" "+helpers.FormatDuration(duration), "migration", migration, "batch", batchNum, "duration_ms", duration.Milliseconds())
+
+		summary.Migrations = append(summary.Migrations, MigrationTiming{Name: migration, Duration: duration})
 	}
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("error committing transaction: %w", err)
+	// Repeatable migrations may depend on anything the versioned migrations
+	// create, so they always run last.
+	if err := applyRepeatableMigrations(ctx, db, batch.tx, o.logger, pendingRepeatables); err != nil {
+		return err
 	}
 
-	fmt.Println("Migrations applied successfully")
+	if err := runHook(ctx, batch.tx, cwd, afterAllHook, o.hooks.AfterAll, HookInfo{Direction: Up, Batch: batchNum}, o); err != nil {
+		return err
+	}
+
+	if err := batch.commit(); err != nil {
+		return err
+	}
+
+	duration := time.Since(summary.Start)
+	o.logger.Info("Migrations applied successfully in "+helpers.FormatDuration(duration), "batch", batchNum, "migrations", len(summary.Migrations), "duration_ms", duration.Milliseconds())
 
 	return nil
 }
+
+// applyStep applies one migration of the batch and returns how long its SQL
+// took to run, and whether it marked the migration dirty. A migration the
+// transaction cannot roll back is marked dirty right before its SQL runs,
+// and stays dirty until it succeeds, so a failure halfway is not forgotten.
+func applyStep(ctx context.Context, db database.Database, batch *batchTx, cwd string, step batchStep, batchNum int, o options) (time.Duration, bool, error) {
+	dirty := false
+
+	if step.unprotected {
+		if err := batch.split(); err != nil {
+			return 0, dirty, err
+		}
+	}
+
+	info := HookInfo{Migration: step.migration, Direction: Up, Batch: batchNum}
+	if err := runHook(ctx, batch.tx, cwd, beforeEachHook, o.hooks.BeforeEach, info, o); err != nil {
+		return 0, dirty, err
+	}
+
+	// The migration is only marked dirty once its before_each hook, which
+	// runs in the transaction, has been committed, right before SQL runs
+	// that the transaction cannot roll back.
+	if step.unprotected {
+		if err := batch.split(); err != nil {
+			return 0, dirty, err
+		}
+		if err := db.MarkDirty(ctx, step.migration, batchNum, string(Up)); err != nil {
+			return 0, dirty, err
+		}
+		dirty = true
+	}
+
+	start := time.Now()
+	err := execStep(ctx, db, batch.tx, step, func(ctx context.Context) error {
+		if !step.withoutTx {
+			return db.ApplyMigration(ctx, batch.tx, step.migration, step.query)
+		}
+		if err := execWithoutTransaction(ctx, db, o.logger, step.query); err != nil {
+			return fmt.Errorf("error executing migration %s: %w", step.migration, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, dirty, err
+	}
+	duration := time.Since(start)
+
+	if step.unprotected {
+		if err := db.DeleteMigration(ctx, batch.tx, step.migration); err != nil {
+			return 0, dirty, err
+		}
+	}
+
+	if err := db.RecordMigration(ctx, batch.tx, step.migration, batchNum, helpers.Checksum([]byte(step.query)), duration); err != nil {
+		return 0, dirty, err
+	}
+
+	if err := runHook(ctx, batch.tx, cwd, afterEachHook, o.hooks.AfterEach, info, o); err != nil {
+		return 0, dirty, err
+	}
+
+	if step.unprotected {
+		return duration, dirty, batch.split()
+	}
+
+	return duration, dirty, nil
+}
+
+// printDryRun writes the rendered SQL ApplyMigrations would execute, in the
+// order it would execute it.
+func printDryRun(w io.Writer, cwd string, pendingMigrations []string, pendingRepeatables []RepeatableMigration, dialect string, vars map[string]string) error {
+	printHook := func(hookFile string) error {
+		query, err := hookSQL(cwd, hookFile, vars)
+		if err != nil || query == "" {
+			return err
+		}
+		fmt.Fprintf(w, "-- %s\n%s\n\n", hookFile, strings.TrimSpace(query))
+		return nil
+	}
+
+	if err := printHook(beforeAllHook); err != nil {
+		return err
+	}
+
+	for _, migration := range pendingMigrations {
+		if err := printHook(beforeEachHook); err != nil {
+			return err
+		}
+
+		query, err := migrationSQL(cwd, migration, Up, dialect, vars)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "-- %s\n%s\n\n", path.Join(migration, migrationFileName(cwd, migration, Up, dialect)), strings.TrimSpace(query))
+
+		if err := printHook(afterEachHook); err != nil {
+			return err
+		}
+	}
+
+	for _, repeatable := range pendingRepeatables {
+		fmt.Fprintf(w, "-- %s\n%s\n\n", path.Join(helpers.RepeatableDir, repeatable.Name), strings.TrimSpace(repeatable.query))
+	}
+
+	return printHook(afterAllHook)
+}
@@ -0,0 +1,295 @@
+package miflo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/helpers"
+)
+
+type ValidationProblem struct {
+	Migration string
+	Message   string
+	// Warning is set for problems that do not stop migrations from being
+	// applied.
+	Warning bool
+}
+
+func (p ValidationProblem) String() string {
+	if p.Migration == "" {
+		return p.Message
+	}
+	return fmt.Sprintf("%s: %s", p.Migration, p.Message)
+}
+
+type ValidationError struct {
+	Problems []ValidationProblem
+}
+
+func (e *ValidationError) Error() string {
+	lines := []string{fmt.Sprintf("migrations directory has %d problem(s):", len(e.Problems))}
+	for _, problem := range e.Problems {
+		lines = append(lines, "  "+problem.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ValidateMigrations checks the layout of the migrations directory. When db
+// is not nil it also reports applied migrations whose directory no longer
+// exists and migrations that have no SQL file for the dialect of db.
+func ValidateMigrations(db database.Database, ctx context.Context, cwd string) ([]ValidationProblem, error) {
+	migrationsDir := path.Join(cwd, "migrations")
+	entries, err := os.ReadDir(migrationsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var dialect string
+	if db != nil {
+		dialect = db.Dialect().Name()
+	}
+
+	var problems []ValidationProblem
+	dirMigrations := make(map[string]bool)
+	timestamps := make(map[int64][]string)
+
+	for _, entry := range entries {
+		if helpers.IsHidden(entry.Name()) {
+			continue
+		}
+
+		if !entry.IsDir() {
+			if isHookFile(entry.Name()) {
+				continue
+			}
+			problems = append(problems, ValidationProblem{Migration: entry.Name(), Message: "stray file in migrations directory"})
+			continue
+		}
+
+		if entry.Name() == helpers.RepeatableDir {
+			repeatableProblems, err := validateRepeatableFiles(migrationsDir)
+			if err != nil {
+				return nil, err
+			}
+			problems = append(problems, repeatableProblems...)
+			continue
+		}
+
+		migration := entry.Name()
+		dirMigrations[migration] = true
+
+		timestamp, name, err := helpers.ParseMigrationDir(migration)
+		if err != nil {
+			problems = append(problems, ValidationProblem{Migration: migration, Message: err.Error()})
+		} else {
+			timestamps[timestamp] = append(timestamps[timestamp], migration)
+			if !helpers.IsValidMigrationName(name) {
+				problems = append(problems, ValidationProblem{Migration: migration, Message: "invalid migration name, only letters and underscores are allowed"})
+			}
+		}
+
+		migrationProblems, err := validateMigrationFiles(migrationsDir, migration, dialect)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, migrationProblems...)
+	}
+
+	var duplicateTimestamps []int64
+	for timestamp, migrations := range timestamps {
+		if len(migrations) > 1 {
+			duplicateTimestamps = append(duplicateTimestamps, timestamp)
+		}
+	}
+	sort.Slice(duplicateTimestamps, func(i, j int) bool { return duplicateTimestamps[i] < duplicateTimestamps[j] })
+
+	for _, timestamp := range duplicateTimestamps {
+		migrations := strings.Join(timestamps[timestamp], ", ")
+		problems = append(problems, ValidationProblem{Migration: "", Message: fmt.Sprintf("duplicate timestamp %d used by %s", timestamp, migrations)})
+	}
+
+	if db != nil {
+		appliedMigrations, err := getAppliedMigrations(ctx, db)
+		if err != nil {
+			return nil, err
+		}
+
+		missing, err := missingMigrations(cwd, dirMigrations, appliedMigrations)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, migration := range missing {
+			problems = append(problems, ValidationProblem{Migration: migration, Message: "migration is applied but its directory is missing"})
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Migration < problems[j].Migration
+	})
+
+	return problems, nil
+}
+
+// missingMigrations returns the applied migrations that have no directory in
+// dirMigrations and were not replaced by a squashed migration.
+func missingMigrations(cwd string, dirMigrations map[string]bool, appliedMigrations []string) ([]string, error) {
+	squashed := make(map[string]bool)
+	for migration := range dirMigrations {
+		replaced, err := squashedMigrations(cwd, migration)
+		if err != nil {
+			return nil, err
+		}
+		for _, original := range replaced {
+			squashed[original] = true
+		}
+	}
+
+	var missing []string
+	for _, migration := range appliedMigrations {
+		if !dirMigrations[migration] && !squashed[migration] {
+			missing = append(missing, migration)
+		}
+	}
+
+	return missing, nil
+}
+
+// ValidationErrors returns the problems that are not warnings.
+func ValidationErrors(problems []ValidationProblem) []ValidationProblem {
+	var errors []ValidationProblem
+	for _, problem := range problems {
+		if !problem.Warning {
+			errors = append(errors, problem)
+		}
+	}
+	return errors
+}
+
+func validateMigrationFiles(migrationsDir string, migration string, dialect string) ([]ValidationProblem, error) {
+	entries, err := os.ReadDir(path.Join(migrationsDir, migration))
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []ValidationProblem
+	files := make(map[string]bool)
+	variants := make(map[string]bool)
+	var upFiles []string
+	var sqlFiles []string
+
+	for _, entry := range entries {
+		if helpers.IsHidden(entry.Name()) {
+			continue
+		}
+
+		direction, variant, ok := parseMigrationFileName(entry.Name())
+		if !ok || entry.IsDir() {
+			problems = append(problems, ValidationProblem{Migration: migration, Message: fmt.Sprintf("stray file %s", entry.Name())})
+			continue
+		}
+
+		files[entry.Name()] = true
+		sqlFiles = append(sqlFiles, entry.Name())
+		if variant != "" {
+			variants[variant] = true
+		}
+		if direction == Up {
+			upFiles = append(upFiles, entry.Name())
+		}
+	}
+
+	irreversible, err := isIrreversible(path.Dir(migrationsDir), migration, dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, direction := range []Direction{Up, Down} {
+		name := string(direction) + ".sql"
+		if files[name] || (direction == Down && irreversible) {
+			continue
+		}
+		if len(variants) < 1 {
+			problems = append(problems, ValidationProblem{Migration: migration, Message: fmt.Sprintf("missing %s", name)})
+			continue
+		}
+		if dialect != "" && !files[migrationFileName(path.Dir(migrationsDir), migration, direction, dialect)] {
+			problems = append(problems, ValidationProblem{Migration: migration, Message: fmt.Sprintf("missing %s or %s.%s.sql", name, direction, dialect)})
+		}
+	}
+
+	for _, name := range upFiles {
+		sqlBytes, err := os.ReadFile(path.Join(migrationsDir, migration, name))
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(string(sqlBytes)) == "" {
+			problems = append(problems, ValidationProblem{Migration: migration, Message: fmt.Sprintf("%s is empty", name)})
+		}
+	}
+
+	for _, name := range sqlFiles {
+		sqlBytes, err := os.ReadFile(path.Join(migrationsDir, migration, name))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := migrationTimeouts(string(sqlBytes), database.Timeouts{}); err != nil {
+			problems = append(problems, ValidationProblem{Migration: migration, Message: fmt.Sprintf("%s: %s", name, err)})
+		}
+	}
+
+	variantNames := make([]string, 0, len(variants))
+	for variant := range variants {
+		variantNames = append(variantNames, variant)
+	}
+	sort.Strings(variantNames)
+
+	for _, variant := range variantNames {
+		up := fmt.Sprintf("up.%s.sql", variant)
+		down := fmt.Sprintf("down.%s.sql", variant)
+		switch {
+		case files[up] && !files[down]:
+			problems = append(problems, ValidationProblem{Migration: migration, Message: fmt.Sprintf("%s has no matching %s, down.sql is used to revert it", up, down), Warning: true})
+		case files[down] && !files[up]:
+			problems = append(problems, ValidationProblem{Migration: migration, Message: fmt.Sprintf("%s has no matching %s, up.sql is used to apply it", down, up), Warning: true})
+		}
+	}
+
+	return problems, nil
+}
+
+func validateRepeatableFiles(migrationsDir string) ([]ValidationProblem, error) {
+	repeatableDir := path.Join(migrationsDir, helpers.RepeatableDir)
+	entries, err := os.ReadDir(repeatableDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []ValidationProblem
+	for _, entry := range entries {
+		if helpers.IsHidden(entry.Name()) {
+			continue
+		}
+
+		name := path.Join(helpers.RepeatableDir, entry.Name())
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			problems = append(problems, ValidationProblem{Migration: name, Message: "stray entry in repeatable directory, only .sql files are allowed"})
+			continue
+		}
+
+		sqlBytes, err := os.ReadFile(path.Join(repeatableDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(string(sqlBytes)) == "" {
+			problems = append(problems, ValidationProblem{Migration: name, Message: "repeatable migration is empty"})
+		}
+	}
+
+	return problems, nil
+}
@@ -0,0 +1,61 @@
+package miflo
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+// dialectVariants lists the variants of a migration file that are tried, in
+// order, for each dialect before falling back to the plain file. libSQL is
+// SQLite, so it also uses SQLite variants.
+var dialectVariants = map[string][]string{
+	"sqlite":   {"sqlite"},
+	"postgres": {"postgres"},
+	"libsql":   {"libsql", "sqlite"},
+}
+
+// migrationFileName returns the name of the file that runs migration in
+// direction on dialect: up.<dialect>.sql or down.<dialect>.sql if it exists,
+// otherwise up.sql or down.sql.
+func migrationFileName(cwd string, migration string, direction Direction, dialect string) string {
+	for _, variant := range dialectVariants[dialect] {
+		name := string(direction) + "." + variant + ".sql"
+		if _, err := os.Stat(path.Join(cwd, "migrations", migration, name)); err == nil {
+			return name
+		}
+	}
+
+	return string(direction) + ".sql"
+}
+
+// migrationSQL reads and renders the file that runs migration in direction
+// on dialect.
+func migrationSQL(cwd string, migration string, direction Direction, dialect string, vars map[string]string) (string, error) {
+	name := migrationFileName(cwd, migration, direction, dialect)
+	return readSQLFile(path.Join(cwd, "migrations", migration, name), vars)
+}
+
+// parseMigrationFileName splits a migration file name such as up.sql or
+// down.postgres.sql into its direction and dialect variant. ok is false for
+// files that are not migration files.
+func parseMigrationFileName(name string) (direction Direction, variant string, ok bool) {
+	base, found := strings.CutSuffix(name, ".sql")
+	if !found {
+		return "", "", false
+	}
+
+	prefix, variant, _ := strings.Cut(base, ".")
+	direction = Direction(prefix)
+	if direction != Up && direction != Down {
+		return "", "", false
+	}
+
+	if variant != "" {
+		if _, known := dialectVariants[variant]; !known {
+			return "", "", false
+		}
+	}
+
+	return direction, variant, true
+}
@@ -0,0 +1,93 @@
+package miflo
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// VarEnvPrefix is the prefix of environment variables that define variables
+// for migration SQL. MIFLO_VAR_schema defines ${schema}.
+const VarEnvPrefix = "MIFLO_VAR_"
+
+var (
+	varPattern     = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+	varNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// RenderSQL replaces ${name} placeholders in query with the value of the
+// variable name. $${ is written as a literal ${. It is an error to use a
+// variable that is not defined.
+func RenderSQL(query string, vars map[string]string) (string, error) {
+	undefined := make(map[string]bool)
+
+	rendered := varPattern.ReplaceAllStringFunc(query, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+
+		name := match[2 : len(match)-1]
+		value, ok := vars[name]
+		if !ok {
+			undefined[name] = true
+			return match
+		}
+		return value
+	})
+
+	if len(undefined) > 0 {
+		names := make([]string, 0, len(undefined))
+		for name := range undefined {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("undefined variable(s) %s, set them with --var or %s<name>", strings.Join(names, ", "), VarEnvPrefix)
+	}
+
+	return rendered, nil
+}
+
+// VarsFromEnv returns the variables defined by MIFLO_VAR_ environment
+// variables.
+func VarsFromEnv() map[string]string {
+	vars := make(map[string]string)
+	for _, env := range os.Environ() {
+		key, value, _ := strings.Cut(env, "=")
+		if name, ok := strings.CutPrefix(key, VarEnvPrefix); ok && name != "" {
+			vars[name] = value
+		}
+	}
+	return vars
+}
+
+// ParseVar parses a key=value variable definition.
+func ParseVar(definition string) (string, string, error) {
+	name, value, ok := strings.Cut(definition, "=")
+	if !ok || !varNamePattern.MatchString(name) {
+		return "", "", fmt.Errorf("invalid variable %q, expected name=value", definition)
+	}
+	return name, value, nil
+}
+
+// readSQLFile reads the SQL file at filePath and renders its variables.
+func readSQLFile(filePath string, vars map[string]string) (string, error) {
+	sqlBytes, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", fmt.Errorf("error reading SQL file %s: %w", filePath, err)
+	}
+
+	query, err := RenderSQL(string(sqlBytes), vars)
+	if err != nil {
+		return "", fmt.Errorf("error rendering SQL file %s: %w", filePath, err)
+	}
+
+	return query, nil
+}
+
+func WithVars(vars map[string]string) Option {
+	return func(o *options) {
+		o.vars = vars
+	}
+}
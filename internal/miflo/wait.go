@@ -0,0 +1,90 @@
+package miflo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/helpers"
+)
+
+var (
+	// ErrNotReachable is returned by WaitForDatabase when ctx is done before
+	// the database could be reached.
+	ErrNotReachable = errors.New("database is not reachable")
+	// ErrStillPending is returned by WaitForDatabase when ctx is done while
+	// more migrations than allowed are pending.
+	ErrStillPending = errors.New("migrations are still pending")
+)
+
+type WaitOptions struct {
+	// MaxPending, when not negative, makes WaitForDatabase also wait until
+	// at most this many migrations are pending. Dirty migrations count as
+	// pending.
+	MaxPending int
+	// Interval is the time between two checks.
+	Interval time.Duration
+	// OnWait, if not nil, is called with the reason before every wait.
+	OnWait func(reason string)
+}
+
+// WaitForDatabase blocks until the database at databaseURL is reachable and,
+// with opts.MaxPending, until enough migrations in cwd have been applied, for
+// example by another instance running miflo up.
+func WaitForDatabase(ctx context.Context, databaseURL string, cwd string, opts WaitOptions) error {
+	report := func(reason string) {
+		if opts.OnWait != nil {
+			opts.OnWait(reason)
+		}
+	}
+
+	retry := database.Retry{Attempts: -1, Delay: opts.Interval, MaxDelay: opts.Interval}
+	db, err := database.Connect(ctx, databaseURL, retry, func(attempt int, delay time.Duration, err error) {
+		report(fmt.Sprintf("database is not reachable, checking again in %s: %v", delay, err))
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w: %v", ErrNotReachable, err)
+		}
+		return err
+	}
+
+	defer db.Close()
+
+	if opts.MaxPending < 0 {
+		return nil
+	}
+
+	for {
+		status, err := GetStatus(db, ctx, cwd)
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("%w: %v", ErrStillPending, err)
+			}
+			return err
+		}
+
+		pending := len(status.Pending)
+		for _, migration := range status.Dirty {
+			if !helpers.Contains(status.Pending, migration) {
+				pending++
+			}
+		}
+		if pending <= opts.MaxPending {
+			return nil
+		}
+
+		delay := retry.Backoff(1)
+		report(fmt.Sprintf("%d migration(s) pending, checking again in %s", pending, delay))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w: %d migration(s) pending", ErrStillPending, pending)
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Package notify tells other systems, such as a chat webhook, about the
+// outcome of migration runs.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/helpers"
+	"github.com/gavsidhu/miflo/internal/miflo"
+)
+
+// AttemptTimeout bounds a single attempt to deliver a notification.
+const AttemptTimeout = 10 * time.Second
+
+// Migration is a migration that ran, as sent in a Notification.
+type Migration struct {
+	Name       string `json:"name"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// Notification is the outcome of a run of up or revert on one target. It is
+// the JSON body posted by Webhook and written to the standard input of
+// Command.
+type Notification struct {
+	// Target is the database URL with its credentials redacted.
+	Target     string      `json:"target"`
+	Direction  string      `json:"direction"`
+	Batch      int         `json:"batch"`
+	Migrations []Migration `json:"migrations"`
+	StartedAt  time.Time   `json:"started_at"`
+	DurationMS int64       `json:"duration_ms"`
+	Success    bool        `json:"success"`
+	// Error is the error the run failed with, with credentials redacted.
+	Error string `json:"error,omitempty"`
+}
+
+// NewNotification describes summary, a run on target.
+func NewNotification(target string, summary miflo.RunSummary) Notification {
+	n := Notification{
+		Target:     target,
+		Direction:  string(summary.Direction),
+		Batch:      summary.Batch,
+		Migrations: []Migration{},
+		StartedAt:  summary.Start,
+		DurationMS: summary.Duration.Milliseconds(),
+		Success:    summary.Err == nil,
+	}
+	for _, migration := range summary.Migrations {
+		n.Migrations = append(n.Migrations, Migration{Name: migration.Name, DurationMS: migration.Duration.Milliseconds()})
+	}
+	if summary.Err != nil {
+		n.Error = helpers.RedactSecrets(summary.Err.Error())
+	}
+	return n
+}
+
+// Notifier delivers notifications.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+	// String names the notifier in errors, without credentials.
+	String() string
+}
+
+// Webhook posts notifications as JSON to URL. Any response status other than
+// 2xx is an error. Errors leave out the path of URL, which holds the secret of
+// chat webhooks.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+func (w Webhook) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "miflo")
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+
+	return nil
+}
+
+func (w Webhook) String() string {
+	u, err := url.Parse(w.URL)
+	if err != nil || u.Host == "" {
+		return "webhook"
+	}
+	return fmt.Sprintf("webhook %s://%s", u.Scheme, u.Host)
+}
+
+// Command runs a shell command for every notification. The notification is
+// written to its standard input as JSON and its main fields are set in the
+// MIFLO_TARGET, MIFLO_DIRECTION, MIFLO_BATCH, MIFLO_MIGRATIONS, MIFLO_SUCCESS
+// and MIFLO_ERROR environment variables. A non-zero exit status is an error.
+type Command struct {
+	Command string
+}
+
+func (c Command) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.Command)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"MIFLO_TARGET="+n.Target,
+		"MIFLO_DIRECTION="+n.Direction,
+		"MIFLO_BATCH="+strconv.Itoa(n.Batch),
+		"MIFLO_MIGRATIONS="+strconv.Itoa(len(n.Migrations)),
+		"MIFLO_SUCCESS="+strconv.FormatBool(n.Success),
+		"MIFLO_ERROR="+n.Error,
+	)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if output = bytes.TrimSpace(output); len(output) > 0 {
+			return fmt.Errorf("%w: %s", err, output)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (c Command) String() string {
+	return fmt.Sprintf("command %q", c.Command)
+}
+
+// Send delivers n with notifier, retrying with exponential backoff as long as
+// retry allows. Every attempt is bounded by AttemptTimeout. onRetry, if not
+// nil, is called before every wait.
+func Send(ctx context.Context, notifier Notifier, n Notification, retry database.Retry, onRetry func(attempt int, delay time.Duration, err error)) error {
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, AttemptTimeout)
+		err := notifier.Notify(attemptCtx, n)
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		err = fmt.Errorf("error notifying %s: %w", notifier, err)
+		if retry.Attempts >= 0 && attempt > retry.Attempts {
+			return err
+		}
+
+		delay := retry.Backoff(attempt)
+		if onRetry != nil {
+			onRetry(attempt, delay, err)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w (gave up waiting: %v)", err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
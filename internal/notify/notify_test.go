@@ -0,0 +1,108 @@
+package notify_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/miflo"
+	"github.com/gavsidhu/miflo/internal/notify"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhook(t *testing.T) {
+	ctx := context.Background()
+	retry := database.Retry{Attempts: 2, Delay: time.Millisecond}
+
+	var requests atomic.Int32
+	var received notify.Notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+
+	summary := miflo.RunSummary{
+		Direction: miflo.Up,
+		Batch:     4,
+		Migrations: []miflo.MigrationTiming{
+			{Name: "1_create_users", Duration: 1500 * time.Millisecond},
+			{Name: "2_create_posts", Duration: 20 * time.Millisecond},
+		},
+		Start:    time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Duration: 2 * time.Second,
+		Err:      errors.New("dial postgres://miflo:hunter2@db/app failed"),
+	}
+	notification := notify.NewNotification("postgres://miflo:xxxxx@db/app", summary)
+
+	var retries []int
+	err := notify.Send(ctx, notify.Webhook{URL: server.URL + "/services/secret"}, notification, retry, func(attempt int, delay time.Duration, err error) {
+		retries = append(retries, attempt)
+		assert.NotContains(t, err.Error(), "secret")
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, retries)
+
+	assert.Equal(t, "postgres://miflo:xxxxx@db/app", received.Target)
+	assert.Equal(t, "up", received.Direction)
+	assert.Equal(t, 4, received.Batch)
+	assert.Equal(t, []notify.Migration{{Name: "1_create_users", DurationMS: 1500}, {Name: "2_create_posts", DurationMS: 20}}, received.Migrations)
+	assert.Equal(t, int64(2000), received.DurationMS)
+	assert.True(t, received.StartedAt.Equal(summary.Start))
+	assert.False(t, received.Success)
+	assert.Equal(t, "dial postgres://miflo:xxxxx@db/app failed", received.Error)
+
+	requests.Store(-10)
+	err = notify.Send(ctx, notify.Webhook{URL: server.URL + "/services/secret"}, notification, retry, nil)
+	assert.ErrorContains(t, err, "502 Bad Gateway")
+	assert.NotContains(t, err.Error(), "secret")
+	assert.Equal(t, int32(-7), requests.Load())
+
+	server.Close()
+	err = notify.Send(ctx, notify.Webhook{URL: server.URL + "/services/secret"}, notification, database.Retry{}, nil)
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "secret")
+}
+
+func TestCommand(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	notification := notify.NewNotification("sqlite:app.db", miflo.RunSummary{
+		Direction:  miflo.Down,
+		Batch:      2,
+		Migrations: []miflo.MigrationTiming{{Name: "1_create_users"}},
+	})
+
+	command := notify.Command{Command: "cd " + dir + ` && cat > notification.json && echo "$MIFLO_TARGET $MIFLO_DIRECTION $MIFLO_BATCH $MIFLO_MIGRATIONS $MIFLO_SUCCESS" > env.txt`}
+	assert.NoError(t, notify.Send(ctx, command, notification, database.Retry{}, nil))
+
+	body, err := os.ReadFile(path.Join(dir, "notification.json"))
+	assert.NoError(t, err)
+
+	var received notify.Notification
+	assert.NoError(t, json.Unmarshal(body, &received))
+	assert.Equal(t, notification.Migrations, received.Migrations)
+
+	env, err := os.ReadFile(path.Join(dir, "env.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "sqlite:app.db down 2 1 true\n", string(env))
+
+	err = notify.Send(ctx, notify.Command{Command: "echo unreachable >&2; exit 3"}, notification, database.Retry{}, nil)
+	assert.ErrorContains(t, err, "exit status 3: unreachable")
+}
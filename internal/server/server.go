@@ -0,0 +1,268 @@
+// Package server exposes the migrations of a database over HTTP, for miflo
+// serve.
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/helpers"
+	"github.com/gavsidhu/miflo/internal/metrics"
+	"github.com/gavsidhu/miflo/internal/miflo"
+)
+
+// ErrRunInProgress is returned with status 409 when an up or revert is
+// requested while another one is running.
+var ErrRunInProgress = errors.New("another up or revert is in progress")
+
+// Config configures the handler returned by New.
+type Config struct {
+	DB  database.Database
+	Cwd string
+	// Token must be sent as a bearer token with every request, except for
+	// /healthz.
+	Token string
+	// Target names the database in the metrics, such as its redacted URL.
+	Target string
+	// UpOptions are passed to ApplyMigrations for /up and /plan, and
+	// RevertOptions to RevertMigrations for /revert.
+	UpOptions     []miflo.Option
+	RevertOptions []miflo.Option
+	// RunTimeout, if positive, bounds every up and revert.
+	RunTimeout time.Duration
+}
+
+type server struct {
+	Config
+	metrics *metrics.Registry
+	// running is held during an up, revert or plan, so runs of this process
+	// do not wait for each other's database lock.
+	running sync.Mutex
+}
+
+// New returns a handler serving:
+//
+//	GET  /healthz  whether the database is reachable, without authentication
+//	GET  /status   the applied migrations with their batch and the pending ones
+//	GET  /plan     the SQL up would run, as text
+//	POST /up       applies the pending migrations
+//	POST /revert   reverts the latest batch
+//	GET  /metrics  the metrics of the database in the OpenMetrics format
+func New(config Config) http.Handler {
+	s := &server{Config: config, metrics: metrics.NewRegistry()}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.method(http.MethodGet, s.healthz))
+	mux.HandleFunc("/status", s.authenticated(s.method(http.MethodGet, s.status)))
+	mux.HandleFunc("/plan", s.authenticated(s.method(http.MethodGet, s.plan)))
+	mux.HandleFunc("/up", s.authenticated(s.method(http.MethodPost, s.up)))
+	mux.HandleFunc("/revert", s.authenticated(s.method(http.MethodPost, s.revert)))
+	mux.HandleFunc("/metrics", s.authenticated(s.method(http.MethodGet, s.serveMetrics)))
+
+	return mux
+}
+
+func (s *server) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="miflo"`)
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *server) method(method string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *server) healthz(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.DB.GetLastBatchNumber(r.Context()); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// AppliedMigration is an applied migration in the response of /status.
+type AppliedMigration struct {
+	Name  string `json:"name"`
+	Batch int    `json:"batch"`
+}
+
+// StatusResponse is the response of /status.
+type StatusResponse struct {
+	miflo.CheckResult
+	LastBatch  int                `json:"last_batch"`
+	Migrations []AppliedMigration `json:"migrations"`
+}
+
+func (s *server) status(w http.ResponseWriter, r *http.Request) {
+	check, err := miflo.CheckMigrations(s.DB, r.Context(), s.Cwd, s.UpOptions...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	batches, err := s.DB.GetAppliedBatches(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	names := make([]string, 0, len(batches))
+	for name := range batches {
+		names = append(names, name)
+	}
+	helpers.SortDirMigrations(names, true)
+	sort.SliceStable(names, func(i, j int) bool {
+		return batches[names[i]] < batches[names[j]]
+	})
+
+	response := StatusResponse{CheckResult: check, Migrations: []AppliedMigration{}}
+	for _, name := range names {
+		response.Migrations = append(response.Migrations, AppliedMigration{Name: name, Batch: batches[name]})
+		response.LastBatch = max(response.LastBatch, batches[name])
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *server) plan(w http.ResponseWriter, r *http.Request) {
+	if !s.running.TryLock() {
+		writeError(w, http.StatusConflict, ErrRunInProgress)
+		return
+	}
+	defer s.running.Unlock()
+
+	var sql bytes.Buffer
+	opts := append(append([]miflo.Option(nil), s.UpOptions...), miflo.WithDryRun(&sql))
+	if err := miflo.ApplyMigrations(s.DB, r.Context(), s.Cwd, opts...); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write(sql.Bytes())
+}
+
+// Migration is a migration that ran, in the response of /up and /revert.
+type Migration struct {
+	Name       string `json:"name"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// RunResponse is the response of /up and /revert.
+type RunResponse struct {
+	Direction  miflo.Direction `json:"direction"`
+	Batch      int             `json:"batch"`
+	Migrations []Migration     `json:"migrations"`
+	DurationMS int64           `json:"duration_ms"`
+	Success    bool            `json:"success"`
+	Error      string          `json:"error,omitempty"`
+}
+
+func (s *server) up(w http.ResponseWriter, r *http.Request) {
+	s.run(w, r, miflo.ApplyMigrations, s.UpOptions)
+}
+
+func (s *server) revert(w http.ResponseWriter, r *http.Request) {
+	s.run(w, r, miflo.RevertMigrations, s.RevertOptions)
+}
+
+// run runs fn on the database and responds with its summary. The run is
+// canceled, and rolled back, if the client disconnects.
+func (s *server) run(w http.ResponseWriter, r *http.Request, fn func(database.Database, context.Context, string, ...miflo.Option) error, options []miflo.Option) {
+	if !s.running.TryLock() {
+		writeError(w, http.StatusConflict, ErrRunInProgress)
+		return
+	}
+	defer s.running.Unlock()
+
+	ctx := r.Context()
+	if s.RunTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.RunTimeout)
+		defer cancel()
+	}
+
+	var summary miflo.RunSummary
+	opts := append(append([]miflo.Option(nil), options...), miflo.WithRunSummary(func(rs miflo.RunSummary) {
+		summary = rs
+		s.metrics.Record(s.Target, rs)
+	}))
+
+	err := fn(s.DB, ctx, s.Cwd, opts...)
+
+	response := RunResponse{
+		Direction:  summary.Direction,
+		Batch:      summary.Batch,
+		Migrations: []Migration{},
+		DurationMS: summary.Duration.Milliseconds(),
+		Success:    err == nil,
+	}
+	for _, migration := range summary.Migrations {
+		response.Migrations = append(response.Migrations, Migration{Name: migration.Name, DurationMS: migration.Duration.Milliseconds()})
+	}
+
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusInternalServerError
+		response.Error = helpers.RedactSecrets(err.Error())
+	}
+
+	writeJSON(w, status, response)
+}
+
+// serveMetrics refreshes the applied and pending counts of the database
+// before writing the metrics, so they are current even when the migrations
+// were applied by another process.
+func (s *server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	status, err := miflo.GetStatus(s.DB, r.Context(), s.Cwd)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	lastBatch, err := s.DB.GetLastBatchNumber(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.metrics.SetStatus(s.Target, status, lastBatch)
+
+	w.Header().Set("Content-Type", metrics.ContentType)
+	_, _ = s.metrics.WriteTo(w)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": helpers.RedactSecrets(err.Error())})
+}
@@ -0,0 +1,134 @@
+package server_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/gavsidhu/miflo/internal/database"
+	"github.com/gavsidhu/miflo/internal/miflo"
+	"github.com/gavsidhu/miflo/internal/server"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeMigration(t *testing.T, cwd string, name string, up string, down string) {
+	t.Helper()
+
+	dir := path.Join(cwd, "migrations", name)
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	assert.NoError(t, os.WriteFile(path.Join(dir, "up.sql"), []byte(up), 0644))
+	assert.NoError(t, os.WriteFile(path.Join(dir, "down.sql"), []byte(down), 0644))
+}
+
+func TestServer(t *testing.T) {
+	cwd := t.TempDir()
+
+	writeMigration(t, cwd, "1_create_users", "CREATE TABLE users (id INTEGER PRIMARY KEY);", "DROP TABLE users;")
+	writeMigration(t, cwd, "2_create_posts", "CREATE TABLE posts (id INTEGER PRIMARY KEY);", "DROP TABLE posts;")
+
+	db, err := database.NewDatabase("sqlite:" + path.Join(cwd, "server.db"))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	handler := server.New(server.Config{DB: db, Cwd: cwd, Token: "secret", Target: "sqlite:server.db"})
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	request := func(method string, endpoint string, token string) (*http.Response, string) {
+		t.Helper()
+
+		req, err := http.NewRequest(method, ts.URL+endpoint, nil)
+		assert.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return resp, string(body)
+	}
+
+	resp, _ := request(http.MethodGet, "/healthz", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = request(http.MethodGet, "/status", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, _ = request(http.MethodPost, "/up", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, _ = request(http.MethodGet, "/up", "secret")
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.Equal(t, http.MethodPost, resp.Header.Get("Allow"))
+
+	resp, body := request(http.MethodGet, "/plan", "secret")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "CREATE TABLE users (id INTEGER PRIMARY KEY);")
+	assert.Contains(t, body, "CREATE TABLE posts (id INTEGER PRIMARY KEY);")
+
+	resp, body = request(http.MethodGet, "/status", "secret")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var status server.StatusResponse
+	assert.NoError(t, json.Unmarshal([]byte(body), &status))
+	assert.Equal(t, miflo.CheckPending, status.Status)
+	assert.Equal(t, []string{"1_create_users", "2_create_posts"}, status.Pending)
+	assert.Empty(t, status.Migrations)
+
+	resp, body = request(http.MethodPost, "/up", "secret")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var run server.RunResponse
+	assert.NoError(t, json.Unmarshal([]byte(body), &run))
+	assert.True(t, run.Success)
+	assert.Equal(t, miflo.Up, run.Direction)
+	assert.Equal(t, 1, run.Batch)
+	assert.Len(t, run.Migrations, 2)
+
+	writeMigration(t, cwd, "3_create_tags", "CREATE TABLE tags (id INTEGER PRIMARY KEY);", "DROP TABLE tags;")
+	resp, _ = request(http.MethodPost, "/up", "secret")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, body = request(http.MethodGet, "/status", "secret")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	status = server.StatusResponse{}
+	assert.NoError(t, json.Unmarshal([]byte(body), &status))
+	assert.Equal(t, miflo.CheckUpToDate, status.Status)
+	assert.Equal(t, 2, status.LastBatch)
+	assert.Equal(t, []server.AppliedMigration{
+		{Name: "1_create_users", Batch: 1},
+		{Name: "2_create_posts", Batch: 1},
+		{Name: "3_create_tags", Batch: 2},
+	}, status.Migrations)
+
+	writeMigration(t, cwd, "4_create_likes", "CREATE TABLE users (id INTEGER PRIMARY KEY);", "SELECT 1;")
+	resp, body = request(http.MethodPost, "/up", "secret")
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	run = server.RunResponse{}
+	assert.NoError(t, json.Unmarshal([]byte(body), &run))
+	assert.False(t, run.Success)
+	assert.Contains(t, run.Error, "users already exists")
+	assert.NoError(t, os.RemoveAll(path.Join(cwd, "migrations", "4_create_likes")))
+
+	resp, body = request(http.MethodPost, "/revert", "secret")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	run = server.RunResponse{}
+	assert.NoError(t, json.Unmarshal([]byte(body), &run))
+	assert.Equal(t, miflo.Down, run.Direction)
+	assert.Equal(t, 2, run.Batch)
+	assert.Equal(t, []server.Migration{{Name: "3_create_tags", DurationMS: run.Migrations[0].DurationMS}}, run.Migrations)
+
+	resp, body = request(http.MethodGet, "/metrics", "secret")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "application/openmetrics-text")
+	assert.Contains(t, body, `miflo_migrations_applied{target="sqlite:server.db"} 2`)
+	assert.Contains(t, body, `miflo_migrations_pending{target="sqlite:server.db"} 1`)
+	assert.Contains(t, body, `miflo_last_batch{target="sqlite:server.db"} 1`)
+	assert.Contains(t, body, `miflo_run_failures_total{target="sqlite:server.db"} 1`)
+}